@@ -2,12 +2,15 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/hpcloud/tail"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/log"
@@ -16,54 +19,287 @@ import (
 
 	"github.com/DRuggeri/bind_query_exporter/collectors"
 	"github.com/DRuggeri/bind_query_exporter/filters"
+	"github.com/DRuggeri/bind_query_exporter/inputs"
 	"github.com/DRuggeri/bind_query_exporter/util"
 )
 
 var Version = "testing"
 
 var (
-	bindQueryLogFile = kingpin.Flag(
-		"log", "Path of the BIND query log to watch. Defaults to '/var/log/bind/queries.log' ($BIND_QUERY_EXPORTER_LOG)",
-	).Envar("BIND_QUERY_EXPORTER_LOG").Default("/var/log/bind/queries.log").String()
+	bindQueryLogFiles = kingpin.Flag(
+		"log", "Path or glob pattern of a BIND query log to watch, optionally followed by static labels for its metrics as in 'PATH;site=dc1,instance=ns1'. May be given more than once. Defaults to '/var/log/bind/queries.log' ($BIND_QUERY_EXPORTER_LOG, one per line)",
+	).Envar("BIND_QUERY_EXPORTER_LOG").Default("/var/log/bind/queries.log").Strings()
+
+	bindQueryStateFile = kingpin.Flag(
+		"log.state-file", "Path of a file to save the read position of every --log file in. When set, the exporter resumes where it stopped after a restart instead of skipping to the end, including the rest of a file that was rotated meanwhile ($BIND_QUERY_EXPORTER_LOG_STATE_FILE)",
+	).Envar("BIND_QUERY_EXPORTER_LOG_STATE_FILE").Default("").String()
+
+	bindQueryStateInterval = kingpin.Flag(
+		"log.state-interval", "How often to write the read positions to --log.state-file. They are also written on shutdown ($BIND_QUERY_EXPORTER_LOG_STATE_INTERVAL)",
+	).Envar("BIND_QUERY_EXPORTER_LOG_STATE_INTERVAL").Default("5s").Duration()
+
+	inputMode = kingpin.Flag(
+		"input", "Where to read BIND queries from: 'file' to tail the files given by --log, 'syslog' to receive them on the --syslog.listen sockets, 'dnstap' to receive dnstap messages on --dnstap.socket, 'replay' to read the --log files once from the beginning, write the resulting metrics to --replay.output and exit ($BIND_QUERY_EXPORTER_INPUT)",
+	).Envar("BIND_QUERY_EXPORTER_INPUT").Default("file").Enum("file", "syslog", "dnstap", "replay")
+
+	syslogListen = kingpin.Flag(
+		"syslog.listen", "Socket to receive RFC3164 or RFC5424 syslog messages on when --input=syslog, as udp://ADDRESS, tcp://ADDRESS, unix://PATH or unixgram://PATH. May be given more than once. Defaults to 'udp://:514' ($BIND_QUERY_EXPORTER_SYSLOG_LISTEN, one per line)",
+	).Envar("BIND_QUERY_EXPORTER_SYSLOG_LISTEN").Default("udp://:514").Strings()
+
+	syslogLabels = kingpin.Flag(
+		"syslog.labels", "Static labels added to the metrics of every query received over syslog, as 'site=dc1,instance=ns1' ($BIND_QUERY_EXPORTER_SYSLOG_LABELS)",
+	).Envar("BIND_QUERY_EXPORTER_SYSLOG_LABELS").Default("").String()
+
+	syslogHostnameLabel = kingpin.Flag(
+		"syslog.hostname-label", "When set, the hostname of the sending server (or its IP address if the message does not carry one) is added to the metrics under this label name ($BIND_QUERY_EXPORTER_SYSLOG_HOSTNAME_LABEL)",
+	).Envar("BIND_QUERY_EXPORTER_SYSLOG_HOSTNAME_LABEL").Default("").String()
+
+	dnstapSocket = kingpin.Flag(
+		"dnstap.socket", "Path of the Frame Streams unix socket to create for BIND's dnstap output when --input=dnstap ($BIND_QUERY_EXPORTER_DNSTAP_SOCKET)",
+	).Envar("BIND_QUERY_EXPORTER_DNSTAP_SOCKET").Default("/var/run/named/dnstap.sock").String()
+
+	dnstapMessage = kingpin.Flag(
+		"dnstap.message", "Which dnstap message to count a query from. 'response' also provides the response code and latency, 'query' must be used if BIND only sends queries ($BIND_QUERY_EXPORTER_DNSTAP_MESSAGE)",
+	).Envar("BIND_QUERY_EXPORTER_DNSTAP_MESSAGE").Default("response").Enum("query", "response")
+
+	dnstapLabels = kingpin.Flag(
+		"dnstap.labels", "Static labels added to the metrics of every query received over dnstap, as 'site=dc1,instance=ns1' ($BIND_QUERY_EXPORTER_DNSTAP_LABELS)",
+	).Envar("BIND_QUERY_EXPORTER_DNSTAP_LABELS").Default("").String()
+
+	dnstapIdentityLabel = kingpin.Flag(
+		"dnstap.identity-label", "When set, the server identity sent in the dnstap messages is added to the metrics under this label name ($BIND_QUERY_EXPORTER_DNSTAP_IDENTITY_LABEL)",
+	).Envar("BIND_QUERY_EXPORTER_DNSTAP_IDENTITY_LABEL").Default("").String()
+
+	replayFormat = kingpin.Flag(
+		"replay.format", "Output of --input=replay: 'text' for the final values in the exposition format, 'openmetrics' for OpenMetrics with a sample every --replay.interval, timestamped from the log lines, for 'promtool tsdb create-blocks-from openmetrics' ($BIND_QUERY_EXPORTER_REPLAY_FORMAT)",
+	).Envar("BIND_QUERY_EXPORTER_REPLAY_FORMAT").Default("text").Enum("text", "openmetrics")
+
+	replayOutput = kingpin.Flag(
+		"replay.output", "File to write the metrics of --input=replay to, '-' for standard output ($BIND_QUERY_EXPORTER_REPLAY_OUTPUT)",
+	).Envar("BIND_QUERY_EXPORTER_REPLAY_OUTPUT").Default("-").String()
+
+	replayInterval = kingpin.Flag(
+		"replay.interval", "Time between the samples written by --replay.format=openmetrics, like a scrape interval ($BIND_QUERY_EXPORTER_REPLAY_INTERVAL)",
+	).Envar("BIND_QUERY_EXPORTER_REPLAY_INTERVAL").Default("1m").Duration()
+
+	snapshotFile = kingpin.Flag(
+		"snapshot.file", "Path of a file to save the values of the Stats, Names, Flags and Groups counters in. When set, the counters are restored from it on startup so they keep growing across restarts ($BIND_QUERY_EXPORTER_SNAPSHOT_FILE)",
+	).Envar("BIND_QUERY_EXPORTER_SNAPSHOT_FILE").Default("").String()
+
+	snapshotInterval = kingpin.Flag(
+		"snapshot.interval", "How often to write the counters to --snapshot.file. They are also written on shutdown ($BIND_QUERY_EXPORTER_SNAPSHOT_INTERVAL)",
+	).Envar("BIND_QUERY_EXPORTER_SNAPSHOT_INTERVAL").Default("1m").Duration()
+
+	seriesTTL = kingpin.Flag(
+		"series.ttl", "Delete the series of bind_query_names_all and bind_query_stats_by_client_and_type that have not been incremented for this long, such as 24h, so names and clients no longer queried do not pile up. 0 keeps them forever ($BIND_QUERY_EXPORTER_SERIES_TTL)",
+	).Envar("BIND_QUERY_EXPORTER_SERIES_TTL").Default("0").Duration()
+
+	namesMaxSeries = kingpin.Flag(
+		"names.max-series", "Maximum number of series of bind_query_names_all. Once reached, queries for names not yet counted are counted in a series whose labels are all '__overflow__'. 0 does not limit it ($BIND_QUERY_EXPORTER_NAMES_MAX_SERIES)",
+	).Envar("BIND_QUERY_EXPORTER_NAMES_MAX_SERIES").Default("0").Int()
+
+	statsMaxSeries = kingpin.Flag(
+		"stats.max-series", "Maximum number of series of bind_query_stats_by_client_and_type. Once reached, queries by clients and types not yet counted are counted in a series whose labels are all '__overflow__'. 0 does not limit it ($BIND_QUERY_EXPORTER_STATS_MAX_SERIES)",
+	).Envar("BIND_QUERY_EXPORTER_STATS_MAX_SERIES").Default("0").Int()
 
 	bindQueryPattern = kingpin.Flag(
-		"pattern", "The regular expression pattern with three capturing matches for the client IP, the queried name, and the query type ($BIND_QUERY_EXPORTER_PATTERN)",
-	).Envar("BIND_QUERY_EXPORTER_LOG").Default(util.LogMatcherDefaultPattern).String()
+		"pattern", "The regular expression pattern with three capturing matches for the client IP, the queried name, and the query type. Named groups (?P<client>...), (?P<name>...), (?P<type>...), (?P<class>...), (?P<flags>...), (?P<server>...), (?P<view>...), (?P<timestamp>...) and (?P<port>...) may be used instead, in any order, along with groups of any other name. The default is read by a built-in parser for the BIND 9 format instead, which also provides class, flags, server, view, port and ecs ($BIND_QUERY_EXPORTER_PATTERN)",
+	).Envar("BIND_QUERY_EXPORTER_PATTERN").Default(util.LogMatcherDefaultPattern).String()
+
+	bindQueryPatternLabels = kingpin.Flag(
+		"pattern.labels", "Comma separated named groups of --pattern to add as labels to the metrics of every query, such as 'class,server' ($BIND_QUERY_EXPORTER_PATTERN_LABELS)",
+	).Envar("BIND_QUERY_EXPORTER_PATTERN_LABELS").Default("").String()
 
 	bindQueryIncludeFile = kingpin.Flag(
-		"names.include.file", "Path to a file of DNS names that this exporter WILL export when the Names filter is enabled. One DNS name per line will be read. ($BIND_QUERY_EXPORTER_NAMES_INCLUDE_FILE)",
+		"names.include.file", "Path to a file of DNS names that this exporter WILL export when the Names filter is enabled. One entry per line will be read: a name, a .suffix, a glob or a re: regular expression. ($BIND_QUERY_EXPORTER_NAMES_INCLUDE_FILE)",
 	).Envar("BIND_QUERY_EXPORTER_NAMES_INCLUDE_FILE").Default("").String()
 
 	bindQueryExcludeFile = kingpin.Flag(
-		"names.exclude.file", "Path to a file of DNS names that this exporter WILL NOT export when the Names filter is enabled. One DNS name per line will be read. ($BIND_QUERY_EXPORTER_NAMES_EXCLUDE_FILE)",
+		"names.exclude.file", "Path to a file of DNS names that this exporter WILL NOT export when the Names filter is enabled. One entry per line will be read: a name, a .suffix, a glob or a re: regular expression. ($BIND_QUERY_EXPORTER_NAMES_EXCLUDE_FILE)",
 	).Envar("BIND_QUERY_EXPORTER_NAMES_EXCLUDE_FILE").Default("").String()
 
+	bindQueryViewIncludeFiles = kingpin.Flag(
+		"names.view.include.file", "VIEW=PATH of a file of DNS names that this exporter WILL export for queries in that view, in place of --names.include.file. May be given more than once ($BIND_QUERY_EXPORTER_NAMES_VIEW_INCLUDE_FILE, one per line)",
+	).Envar("BIND_QUERY_EXPORTER_NAMES_VIEW_INCLUDE_FILE").Strings()
+
+	bindQueryViewExcludeFiles = kingpin.Flag(
+		"names.view.exclude.file", "VIEW=PATH of a file of DNS names that this exporter WILL NOT export for queries in that view, in place of --names.exclude.file. May be given more than once ($BIND_QUERY_EXPORTER_NAMES_VIEW_EXCLUDE_FILE, one per line)",
+	).Envar("BIND_QUERY_EXPORTER_NAMES_VIEW_EXCLUDE_FILE").Strings()
+
 	bindQueryExcludeClientsFile = kingpin.Flag(
-		"names.exclude-clients.file", "Path to a file of reverse names or IP addresses that this exporter will ignore. One entry per line will be read. ($BIND_QUERY_EXPORTER_NAMES_EXCLUDE_CLIENTS_FILE)",
+		"names.exclude-clients.file", "Path to a file of reverse names, IP addresses or CIDR ranges that this exporter will ignore. One entry per line will be read. ($BIND_QUERY_EXPORTER_NAMES_EXCLUDE_CLIENTS_FILE)",
 	).Envar("BIND_QUERY_EXPORTER_NAMES_EXCLUDE_CLIENTS_FILE").Default("").String()
 
 	bindQueryIncludeClientsFile = kingpin.Flag(
-		"names.include-clients.file", "Path to a file of reverse names or IP addresses that this exporter will capture. All others will be ignored. One entry per line will be read. ($BIND_QUERY_EXPORTER_NAMES_INCLUDE_CLIENTS_FILE)",
+		"names.include-clients.file", "Path to a file of reverse names, IP addresses or CIDR ranges that this exporter will capture. All others will be ignored. One entry per line will be read. ($BIND_QUERY_EXPORTER_NAMES_INCLUDE_CLIENTS_FILE)",
 	).Envar("BIND_QUERY_EXPORTER_NAMES_INCLUDE_CLIENTS_FILE").Default("").String()
 
+	bindQueryNamesAggregate = kingpin.Flag(
+		"names.aggregate", "How to count names in bind_query_names_all: 'full' for the queried name, 'registrable' for its registrable domain (eTLD+1) by the Public Suffix List, such as example.co.uk for foo.bar.example.co.uk, or 'depth' for its last --names.aggregate.depth labels ($BIND_QUERY_EXPORTER_NAMES_AGGREGATE)",
+	).Envar("BIND_QUERY_EXPORTER_NAMES_AGGREGATE").Default("full").Enum("full", "registrable", "depth")
+
+	bindQueryNamesAggregateDepth = kingpin.Flag(
+		"names.aggregate.depth", "Number of labels to keep with --names.aggregate=depth, as 2 for corp.internal from host.site.corp.internal ($BIND_QUERY_EXPORTER_NAMES_AGGREGATE_DEPTH)",
+	).Envar("BIND_QUERY_EXPORTER_NAMES_AGGREGATE_DEPTH").Default("2").Int()
+
+	bindQueryNamesPublicSuffixList = kingpin.Flag(
+		"names.public-suffix-list", "Path of a public_suffix_list.dat file from publicsuffix.org to use with --names.aggregate=registrable in place of the list compiled into the exporter. It is reloaded along with the include/exclude lists ($BIND_QUERY_EXPORTER_NAMES_PUBLIC_SUFFIX_LIST)",
+	).Envar("BIND_QUERY_EXPORTER_NAMES_PUBLIC_SUFFIX_LIST").Default("").String()
+
+	bindQueryNamesCaptureClientMap = kingpin.Flag(
+		"names.capture-client-map", "Add the labels of --clients.map.file for the client of each query, such as its site, to the Names metrics ($BIND_QUERY_EXPORTER_NAMES_CAPTURE_CLIENT_MAP)",
+	).Envar("BIND_QUERY_EXPORTER_NAMES_CAPTURE_CLIENT_MAP").Default("false").Bool()
+
+	bindQueryNamesTopK = kingpin.Flag(
+		"names.top-k", "When above 0, export only the K most queried names (or pairs of name and client with --names.capture-client) as bind_query_names_top, with all others summed up as '__other__', in place of bind_query_names_all. Memory stays bounded however many names are queried ($BIND_QUERY_EXPORTER_NAMES_TOP_K)",
+	).Envar("BIND_QUERY_EXPORTER_NAMES_TOP_K").Default("0").Int()
+
 	bindQueryNamesCaptureClient = kingpin.Flag(
 		"names.capture-client", "Enable capturing the client making the client IP or name as part of the vector. WARNING: This will can lead to lots of metrics in your Prometheus database! ($BIND_QUERY_EXPORTER_NAMES_CAPTURE_CLIENT)",
 	).Envar("BIND_QUERY_EXPORTER_NAMES_CAPTURE_CLIENT").Default("false").Bool()
 
+	bindQueryNamesCaptureView = kingpin.Flag(
+		"names.capture-view", "Add the view of each query as a 'view' label to the Names metrics ($BIND_QUERY_EXPORTER_NAMES_CAPTURE_VIEW)",
+	).Envar("BIND_QUERY_EXPORTER_NAMES_CAPTURE_VIEW").Default("false").Bool()
+
 	bindQueryNamesReverseLookup = kingpin.Flag(
 		"names.reverse-lookup", "When capture-client is enabled for the Names collector, perform a reverse DNS lookup to identify the client in the vector instead of the IP. ($BIND_QUERY_EXPORTER_NAMES_REVERSE_LOOKUP)",
 	).Envar("BIND_QUERY_EXPORTER_NAMES_REVERSE_LOOKUP").Default("false").Bool()
 
+	bindQueryStatsIncludeFile = kingpin.Flag(
+		"stats.include.file", "Path to a file of DNS names that the Stats collector WILL count. Takes the same entries as --names.include.file ($BIND_QUERY_EXPORTER_STATS_INCLUDE_FILE)",
+	).Envar("BIND_QUERY_EXPORTER_STATS_INCLUDE_FILE").Default("").String()
+
+	bindQueryStatsExcludeFile = kingpin.Flag(
+		"stats.exclude.file", "Path to a file of DNS names that the Stats collector WILL NOT count. Takes the same entries as --names.exclude.file ($BIND_QUERY_EXPORTER_STATS_EXCLUDE_FILE)",
+	).Envar("BIND_QUERY_EXPORTER_STATS_EXCLUDE_FILE").Default("").String()
+
+	bindQueryStatsViewIncludeFiles = kingpin.Flag(
+		"stats.view.include.file", "VIEW=PATH of a file of DNS names that the Stats collector WILL count for queries in that view, in place of --stats.include.file. May be given more than once ($BIND_QUERY_EXPORTER_STATS_VIEW_INCLUDE_FILE, one per line)",
+	).Envar("BIND_QUERY_EXPORTER_STATS_VIEW_INCLUDE_FILE").Strings()
+
+	bindQueryStatsViewExcludeFiles = kingpin.Flag(
+		"stats.view.exclude.file", "VIEW=PATH of a file of DNS names that the Stats collector WILL NOT count for queries in that view, in place of --stats.exclude.file. May be given more than once ($BIND_QUERY_EXPORTER_STATS_VIEW_EXCLUDE_FILE, one per line)",
+	).Envar("BIND_QUERY_EXPORTER_STATS_VIEW_EXCLUDE_FILE").Strings()
+
+	bindQueryStatsExcludeClientsFile = kingpin.Flag(
+		"stats.exclude-clients.file", "Path to a file of reverse names, IP addresses or CIDR ranges whose queries the Stats collector will ignore. One entry per line will be read. ($BIND_QUERY_EXPORTER_STATS_EXCLUDE_CLIENTS_FILE)",
+	).Envar("BIND_QUERY_EXPORTER_STATS_EXCLUDE_CLIENTS_FILE").Default("").String()
+
+	bindQueryStatsIncludeClientsFile = kingpin.Flag(
+		"stats.include-clients.file", "Path to a file of reverse names, IP addresses or CIDR ranges whose queries the Stats collector will count. All others will be ignored. One entry per line will be read. ($BIND_QUERY_EXPORTER_STATS_INCLUDE_CLIENTS_FILE)",
+	).Envar("BIND_QUERY_EXPORTER_STATS_INCLUDE_CLIENTS_FILE").Default("").String()
+
+	bindQueryStatsCaptureClientMap = kingpin.Flag(
+		"stats.capture-client-map", "Add the labels of --clients.map.file for the client of each query, such as its site, to the Stats metrics ($BIND_QUERY_EXPORTER_STATS_CAPTURE_CLIENT_MAP)",
+	).Envar("BIND_QUERY_EXPORTER_STATS_CAPTURE_CLIENT_MAP").Default("false").Bool()
+
+	bindQueryStatsTopK = kingpin.Flag(
+		"stats.top-k", "When above 0 and --stats.capture-client is enabled, export only the K busiest clients and query types as bind_query_stats_top_clients, with all others summed up as '__other__', in place of bind_query_stats_by_client_and_type ($BIND_QUERY_EXPORTER_STATS_TOP_K)",
+	).Envar("BIND_QUERY_EXPORTER_STATS_TOP_K").Default("0").Int()
+
 	bindQueryStatsCaptureClient = kingpin.Flag(
 		"stats.capture-client", "Enable capturing the client making the client IP or name as part of the vector. WARNING: This will can lead to lots of metrics in your Prometheus database! ($BIND_QUERY_EXPORTER_STATS_CAPTURE_CLIENT)",
 	).Envar("BIND_QUERY_EXPORTER_STATS_CAPTURE_CLIENT").Default("false").Bool()
 
+	bindQueryStatsCaptureView = kingpin.Flag(
+		"stats.capture-view", "Add the view of each query as a 'view' label to the Stats metrics ($BIND_QUERY_EXPORTER_STATS_CAPTURE_VIEW)",
+	).Envar("BIND_QUERY_EXPORTER_STATS_CAPTURE_VIEW").Default("false").Bool()
+
 	bindQueryStatsReverseLookup = kingpin.Flag(
-		"stats.reverse-lookup", "When capture-client is enabled for the Stats collector, perform a reverse DNS lookup to identify the client in the vector instead of the IP. WARNING: this will create queries to your DNS server which will probably be seen by this exporter... triggering an infinite loop of lookups if you do not have a DNS cache configured!!!! ($BIND_QUERY_EXPORTER_STATS_REVERSE_LOOKUP)",
+		"stats.reverse-lookup", "When capture-client is enabled for the Stats collector, perform a reverse DNS lookup to identify the client in the vector instead of the IP. Lookups are cached and made in the background, and the IP is used until they complete. WARNING: the lookups are queries to your DNS server which will probably be seen by this exporter ($BIND_QUERY_EXPORTER_STATS_REVERSE_LOOKUP)",
 	).Envar("BIND_QUERY_EXPORTER_STATS_REVERSE_LOOKUP").Default("false").Bool()
 
+	bindQueryFlagsCaptureClient = kingpin.Flag(
+		"flags.capture-client", "Enable capturing the client making the query by DNS cookie as part of the Flags collector, to find clients that do not send cookies. WARNING: This will can lead to lots of metrics in your Prometheus database! ($BIND_QUERY_EXPORTER_FLAGS_CAPTURE_CLIENT)",
+	).Envar("BIND_QUERY_EXPORTER_FLAGS_CAPTURE_CLIENT").Default("false").Bool()
+
+	bindQueryFlagsReverseLookup = kingpin.Flag(
+		"flags.reverse-lookup", "When capture-client is enabled for the Flags collector, perform a reverse DNS lookup to identify the client in the vector instead of the IP. ($BIND_QUERY_EXPORTER_FLAGS_REVERSE_LOOKUP)",
+	).Envar("BIND_QUERY_EXPORTER_FLAGS_REVERSE_LOOKUP").Default("false").Bool()
+
+	clientNamesFiles = kingpin.Flag(
+		"clients.names.file", "FORMAT:PATH of a file that names clients by address for --stats.reverse-lookup, --names.reverse-lookup and --flags.reverse-lookup, asked before DNS. FORMAT is dhcpd for an ISC dhcpd.leases file, kea for a Kea CSV lease file, dnsmasq for a dnsmasq.leases file or hosts for a file like /etc/hosts. May be given more than once, the first file naming a client winning ($BIND_QUERY_EXPORTER_CLIENTS_NAMES_FILE, one per line)",
+	).Envar("BIND_QUERY_EXPORTER_CLIENTS_NAMES_FILE").Strings()
+
+	reverseLookupDNS = kingpin.Flag(
+		"reverse-lookup.dns", "Look clients that no --clients.names.file names up in DNS. Disable with --no-reverse-lookup.dns to name clients from the files only, without any DNS traffic ($BIND_QUERY_EXPORTER_REVERSE_LOOKUP_DNS)",
+	).Envar("BIND_QUERY_EXPORTER_REVERSE_LOOKUP_DNS").Default("true").Bool()
+
+	reverseLookupServer = kingpin.Flag(
+		"reverse-lookup.server", "[udp://|tcp://|tls://]HOST[:PORT] of a DNS server to make reverse lookups at instead of the system resolver. The exporter ignores its own lookups when this server logs them ($BIND_QUERY_EXPORTER_REVERSE_LOOKUP_SERVER)",
+	).Envar("BIND_QUERY_EXPORTER_REVERSE_LOOKUP_SERVER").Default("").String()
+
+	reverseLookupTLSServerName = kingpin.Flag(
+		"reverse-lookup.tls-server-name", "Name to check the certificate of a tls:// --reverse-lookup.server against, if not its HOST ($BIND_QUERY_EXPORTER_REVERSE_LOOKUP_TLS_SERVER_NAME)",
+	).Envar("BIND_QUERY_EXPORTER_REVERSE_LOOKUP_TLS_SERVER_NAME").Default("").String()
+
+	reverseLookupTimeout = kingpin.Flag(
+		"reverse-lookup.timeout", "How long to wait for --reverse-lookup.server to answer a reverse lookup ($BIND_QUERY_EXPORTER_REVERSE_LOOKUP_TIMEOUT)",
+	).Envar("BIND_QUERY_EXPORTER_REVERSE_LOOKUP_TIMEOUT").Default("2s").Duration()
+
+	reverseLookupCacheSize = kingpin.Flag(
+		"reverse-lookup.cache-size", "Number of client addresses whose reverse lookup is cached for --stats.reverse-lookup, --names.reverse-lookup and --flags.reverse-lookup ($BIND_QUERY_EXPORTER_REVERSE_LOOKUP_CACHE_SIZE)",
+	).Envar("BIND_QUERY_EXPORTER_REVERSE_LOOKUP_CACHE_SIZE").Default("10000").Int()
+
+	reverseLookupTTL = kingpin.Flag(
+		"reverse-lookup.ttl", "How long the name a client address resolved to is cached before it is looked up again ($BIND_QUERY_EXPORTER_REVERSE_LOOKUP_TTL)",
+	).Envar("BIND_QUERY_EXPORTER_REVERSE_LOOKUP_TTL").Default("1h").Duration()
+
+	reverseLookupNegativeTTL = kingpin.Flag(
+		"reverse-lookup.negative-ttl", "How long a client address that did not resolve is cached before it is looked up again ($BIND_QUERY_EXPORTER_REVERSE_LOOKUP_NEGATIVE_TTL)",
+	).Envar("BIND_QUERY_EXPORTER_REVERSE_LOOKUP_NEGATIVE_TTL").Default("5m").Duration()
+
+	reverseLookupWorkers = kingpin.Flag(
+		"reverse-lookup.workers", "Number of reverse lookups made at the same time ($BIND_QUERY_EXPORTER_REVERSE_LOOKUP_WORKERS)",
+	).Envar("BIND_QUERY_EXPORTER_REVERSE_LOOKUP_WORKERS").Default("4").Int()
+
+	reverseLookupRate = kingpin.Flag(
+		"reverse-lookup.rate", "Maximum number of reverse lookups per second, or 0 for no limit ($BIND_QUERY_EXPORTER_REVERSE_LOOKUP_RATE)",
+	).Envar("BIND_QUERY_EXPORTER_REVERSE_LOOKUP_RATE").Default("50").Float64()
+
+	clientMapFile = kingpin.Flag(
+		"clients.map.file", "Path to a file that maps client networks to labels, one 'CIDR LABEL=VALUE ...' per line such as '10.1.0.0/16 site=nyc', for --stats.capture-client-map and --names.capture-client-map ($BIND_QUERY_EXPORTER_CLIENTS_MAP_FILE)",
+	).Envar("BIND_QUERY_EXPORTER_CLIENTS_MAP_FILE").Default("").String()
+
+	bindQueryGroupsFile = kingpin.Flag(
+		"groups.file", "Path to a YAML or CSV file that assigns DNS names to groups such as service=payments for the Groups collector. Names are matched like --names.include.file ($BIND_QUERY_EXPORTER_GROUPS_FILE)",
+	).Envar("BIND_QUERY_EXPORTER_GROUPS_FILE").Default("").String()
+
+	bindQueryGroupsCaptureView = kingpin.Flag(
+		"groups.capture-view", "Add the view of each query as a 'view' label to the Groups metrics ($BIND_QUERY_EXPORTER_GROUPS_CAPTURE_VIEW)",
+	).Envar("BIND_QUERY_EXPORTER_GROUPS_CAPTURE_VIEW").Default("false").Bool()
+
+	uniqueWindow = kingpin.Flag(
+		"unique.window", "Sliding window the Unique collector counts distinct clients and names over, at least 6s ($BIND_QUERY_EXPORTER_UNIQUE_WINDOW)",
+	).Envar("BIND_QUERY_EXPORTER_UNIQUE_WINDOW").Default("1h").Duration()
+
+	uniqueBy = kingpin.Flag(
+		"unique.by", "Comma separated keys to count distinct clients and names by in the Unique collector: 'type' for the query type, 'entry' for the entry of --unique.include.file the name matches ($BIND_QUERY_EXPORTER_UNIQUE_BY)",
+	).Envar("BIND_QUERY_EXPORTER_UNIQUE_BY").Default("").String()
+
+	uniqueIncludeFile = kingpin.Flag(
+		"unique.include.file", "Path to a file of DNS names that the Unique collector WILL count, one entry per line like --names.include.file ($BIND_QUERY_EXPORTER_UNIQUE_INCLUDE_FILE)",
+	).Envar("BIND_QUERY_EXPORTER_UNIQUE_INCLUDE_FILE").Default("").String()
+
+	uniqueExcludeFile = kingpin.Flag(
+		"unique.exclude.file", "Path to a file of DNS names that the Unique collector WILL NOT count, one entry per line like --names.exclude.file ($BIND_QUERY_EXPORTER_UNIQUE_EXCLUDE_FILE)",
+	).Envar("BIND_QUERY_EXPORTER_UNIQUE_EXCLUDE_FILE").Default("").String()
+
+	uniqueCaptureClientMap = kingpin.Flag(
+		"unique.capture-client-map", "Add the labels of --clients.map.file for the client of each query, such as its site, to the Unique metrics ($BIND_QUERY_EXPORTER_UNIQUE_CAPTURE_CLIENT_MAP)",
+	).Envar("BIND_QUERY_EXPORTER_UNIQUE_CAPTURE_CLIENT_MAP").Default("false").Bool()
+
+	uniqueCaptureView = kingpin.Flag(
+		"unique.capture-view", "Add the view of each query as a 'view' label to the Unique metrics ($BIND_QUERY_EXPORTER_UNIQUE_CAPTURE_VIEW)",
+	).Envar("BIND_QUERY_EXPORTER_UNIQUE_CAPTURE_VIEW").Default("false").Bool()
+
+	pipelineBufferSize = kingpin.Flag(
+		"pipeline.buffer-size", "Number of parsed events to queue for each collector before events are dropped for that collector ($BIND_QUERY_EXPORTER_PIPELINE_BUFFER_SIZE)",
+	).Envar("BIND_QUERY_EXPORTER_PIPELINE_BUFFER_SIZE").Default("1024").Int()
+
 	filterCollectors = kingpin.Flag(
-		"filter.collectors", "Comma separated collectors to enable (Stats,Names) ($BIND_QUERY_EXPORTER_FILTER_COLLECTORS)",
+		"filter.collectors", "Comma separated collectors to enable (Stats,Names,Flags,Groups,Unique) ($BIND_QUERY_EXPORTER_FILTER_COLLECTORS)",
 	).Envar("BIND_QUERY_EXPORTER_FILTER_COLLECTORS").Default("Stats").String()
 
 	metricsNamespace = kingpin.Flag(
@@ -83,6 +319,10 @@ var (
 	).Envar("BIND_QUERY_EXPORTER_WEB_AUTH_USERNAME").String()
 	authPassword = ""
 
+	enableReload = kingpin.Flag(
+		"web.enable-reload", "Enable the /-/reload endpoint, which reloads the include/exclude lists on a POST request. It is protected by the same basic auth as the metrics, which must be set up ($BIND_QUERY_EXPORTER_WEB_ENABLE_RELOAD)",
+	).Envar("BIND_QUERY_EXPORTER_WEB_ENABLE_RELOAD").Default("false").Bool()
+
 	tlsCertFile = kingpin.Flag(
 		"web.tls.cert_file", "Path to a file that contains the TLS certificate (PEM format). If the certificate is signed by a certificate authority, the file should be the concatenation of the server's certificate, any intermediates, and the CA's certificate ($BIND_QUERY_EXPORTER_WEB_TLS_CERTFILE)",
 	).Envar("BIND_QUERY_EXPORTER_WEB_TLS_KEYFILE").ExistingFile()
@@ -117,12 +357,10 @@ func (h *basicAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.handler(w, r)
 }
 
-func prometheusHandler() http.Handler {
-	handler := promhttp.Handler()
-
+func authenticated(handler http.HandlerFunc) http.Handler {
 	if *authUsername != "" && authPassword != "" {
-		handler = &basicAuthHandler{
-			handler:  promhttp.Handler().ServeHTTP,
+		return &basicAuthHandler{
+			handler:  handler,
 			username: *authUsername,
 			password: authPassword,
 		}
@@ -131,6 +369,123 @@ func prometheusHandler() http.Handler {
 	return handler
 }
 
+func prometheusHandler() http.Handler {
+	return authenticated(promhttp.Handler().ServeHTTP)
+}
+
+// Gathers the list files of a collector from its flags
+func filterFiles(include string, exclude string, includeClients string, excludeClients string, viewInclude []string, viewExclude []string) (collectors.FilterFiles, error) {
+	viewIncludeFiles, err := parseViewFiles(viewInclude)
+	if err != nil {
+		return collectors.FilterFiles{}, fmt.Errorf("invalid view include file: %s", err)
+	}
+	viewExcludeFiles, err := parseViewFiles(viewExclude)
+	if err != nil {
+		return collectors.FilterFiles{}, fmt.Errorf("invalid view exclude file: %s", err)
+	}
+	return collectors.FilterFiles{
+		Include:        include,
+		Exclude:        exclude,
+		IncludeClients: includeClients,
+		ExcludeClients: excludeClients,
+		ViewInclude:    viewIncludeFiles,
+		ViewExclude:    viewExcludeFiles,
+	}, nil
+}
+
+// Makes sure the labels of the client map do not clash with any other label
+// of the metrics they are added to
+func checkClientMapLabels(clientMap *util.ClientMap, sourceLabels []string) error {
+	reserved := append([]string{"client", "name", "type", "rcode", "le", "view", "edns_version", "transport", "cookie"}, sourceLabels...)
+	for _, name := range clientMap.LabelNames() {
+		for _, other := range reserved {
+			if name == other {
+				return fmt.Errorf("the client map label `%s` is already used by the metrics", name)
+			}
+		}
+	}
+	return nil
+}
+
+// Splits the keys of --unique.by
+func parseUniqueBy(spec string) (byType bool, byEntry bool, err error) {
+	for _, key := range strings.Split(spec, ",") {
+		switch strings.TrimSpace(key) {
+		case "":
+		case "type":
+			byType = true
+		case "entry":
+			byEntry = true
+		default:
+			return false, false, fmt.Errorf("`%s` is not a key to count distinct clients and names by", key)
+		}
+	}
+	return byType, byEntry, nil
+}
+
+// Splits VIEW=PATH values into a map of view to path
+func parseViewFiles(specs []string) (map[string]string, error) {
+	files := make(map[string]string)
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("`%s` is not of the form VIEW=PATH", spec)
+		}
+		files[parts[0]] = parts[1]
+	}
+	return files, nil
+}
+
+// Runs every source through the collectors from the beginning, writes the
+// result and returns the exit status
+func replay(matcher *util.LogMatcher, dispatcher *util.Dispatcher, sources []inputs.FileSource, registry *prometheus.Registry) int {
+	out := os.Stdout
+	if *replayOutput != "-" {
+		file, err := os.Create(*replayOutput)
+		if err != nil {
+			log.Errorln("Failed to create replay output:", *replayOutput, err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+
+	var recorder *util.OpenMetricsRecorder
+	var sample func(time.Time) error
+	if *replayFormat == "openmetrics" {
+		recorder = util.NewOpenMetricsRecorder(registry)
+		defer recorder.Close()
+		sample = recorder.Record
+	}
+
+	inputs.SortSourcesByAge(sources)
+	replayer := inputs.NewReplayer(matcher, dispatcher, *replayInterval, sample)
+	for _, source := range sources {
+		log.Infoln("Replaying", source.Path, source.Labels)
+		if err := replayer.Replay(source); err != nil {
+			log.Errorln("Failed to replay file:", source.Path, err)
+			return 1
+		}
+	}
+	if err := replayer.Finish(); err != nil {
+		log.Errorln("Failed to replay:", err)
+		return 1
+	}
+	dispatcher.Close()
+
+	var err error
+	if recorder != nil {
+		err = recorder.Write(out)
+	} else {
+		err = util.WriteText(out, registry)
+	}
+	if err != nil {
+		log.Errorln("Failed to write replay output:", *replayOutput, err)
+		return 1
+	}
+	return 0
+}
+
 func main() {
 	log.AddFlags(kingpin.CommandLine)
 	kingpin.Version(Version)
@@ -157,17 +512,27 @@ func main() {
 		   - Call the describe function to feed the channel (which blocks until the consume function eats a message)
 		   - When the describe function exits after returning the last item, close the channel to end the background consume function
 		*/
-		bogusChan := make(chan string)
+		dispatcher := util.NewDispatcher(*metricsNamespace, &matcher, 0, nil, nil)
+
+		fmt.Println("Pipeline")
+		out = make(chan *prometheus.Desc)
+		go eatOutput(out)
+		dispatcher.Describe(out)
+		close(out)
 
 		fmt.Println("Stats")
-		statsCollector := collectors.NewStatsCollector(*metricsNamespace, &bogusChan, &matcher, *bindQueryStatsCaptureClient)
+		statsCollector, err := collectors.NewStatsCollector(*metricsNamespace, dispatcher, collectors.FilterFiles{}, nil, *bindQueryStatsTopK, nil, nil, 0, *bindQueryStatsCaptureClient, *bindQueryStatsCaptureView, *bindQueryStatsReverseLookup)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
 		out = make(chan *prometheus.Desc)
 		go eatOutput(out)
 		statsCollector.Describe(out)
 		close(out)
 
 		fmt.Println("Names")
-		namesCollector, err := collectors.NewNamesCollector(*metricsNamespace, &bogusChan, &matcher, *bindQueryIncludeFile, *bindQueryExcludeFile, *bindQueryIncludeClientsFile, *bindQueryExcludeClientsFile, *bindQueryNamesCaptureClient)
+		namesCollector, err := collectors.NewNamesCollector(*metricsNamespace, dispatcher, collectors.FilterFiles{}, nil, nil, *bindQueryNamesTopK, nil, nil, 0, *bindQueryNamesCaptureClient, *bindQueryNamesCaptureView, *bindQueryNamesReverseLookup)
 		if err != nil {
 			log.Error(err)
 			os.Exit(1)
@@ -177,18 +542,146 @@ func main() {
 		namesCollector.Describe(out)
 		close(out)
 
+		fmt.Println("Series")
+		expirer := util.NewSeriesExpirer(*metricsNamespace, time.Hour)
+		limiter := util.NewSeriesLimiter(*metricsNamespace)
+		out = make(chan *prometheus.Desc)
+		go eatOutput(out)
+		expirer.Describe(out)
+		limiter.Describe(out)
+		close(out)
+
+		fmt.Println("Reload")
+		reloader := util.NewReloader(*metricsNamespace, "reload", "include/exclude lists")
+		out = make(chan *prometheus.Desc)
+		go eatOutput(out)
+		reloader.Describe(out)
+		close(out)
+
+		fmt.Println("Client names reload")
+		namesReloader := util.NewReloader(*metricsNamespace, "client_names_reload", "client name files")
+		out = make(chan *prometheus.Desc)
+		go eatOutput(out)
+		namesReloader.Describe(out)
+		close(out)
+
+		fmt.Println("Reverse lookup")
+		resolver := util.NewReverseResolver(*metricsNamespace, nil, 1, time.Hour, time.Hour, 1, 0)
+		out = make(chan *prometheus.Desc)
+		go eatOutput(out)
+		resolver.Describe(out)
+		close(out)
+		resolver.Close()
+
+		fmt.Println("Flags")
+		flagsCollector := collectors.NewFlagsCollector(*metricsNamespace, dispatcher, *bindQueryFlagsCaptureClient, *bindQueryFlagsReverseLookup)
+		out = make(chan *prometheus.Desc)
+		go eatOutput(out)
+		flagsCollector.Describe(out)
+		close(out)
+
+		fmt.Println("Groups")
+		groupsCollector, err := collectors.NewGroupsCollector(*metricsNamespace, dispatcher, "", *bindQueryGroupsCaptureView)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		out = make(chan *prometheus.Desc)
+		go eatOutput(out)
+		groupsCollector.Describe(out)
+		close(out)
+
+		fmt.Println("Unique")
+		uniqueCollector, err := collectors.NewUniqueCollector(*metricsNamespace, dispatcher, collectors.FilterFiles{}, time.Hour, false, false, nil, *uniqueCaptureView)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		out = make(chan *prometheus.Desc)
+		go eatOutput(out)
+		uniqueCollector.Describe(out)
+		close(out)
+
 		os.Exit(0)
 	}
 
 	log.Infoln("Starting bind_query_exporter", Version)
 	authPassword = os.Getenv("BIND_QUERY_EXPORTER_WEB_AUTH_PASSWORD")
-
-	fi, err := os.Stat(*bindQueryLogFile)
-	if err != nil {
-		log.Errorln("Failed to stat file:", *bindQueryLogFile, err)
+	if *enableReload && (*authUsername == "" || authPassword == "") {
+		log.Errorln("The /-/reload endpoint requires basic auth: set --web.auth.username and $BIND_QUERY_EXPORTER_WEB_AUTH_PASSWORD")
 		os.Exit(1)
 	}
 
+	var labelSets []map[string]string
+	var sources []inputs.FileSource
+	var state *inputs.StateFile
+	var syslogListeners []*inputs.SyslogListener
+	var dnstapListener *inputs.DnstapListener
+	switch *inputMode {
+	case "file", "replay":
+		var err error
+		sources, err = inputs.ParseFileSources(*bindQueryLogFiles)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		for _, source := range sources {
+			if _, err := os.Stat(source.Path); err != nil {
+				log.Errorln("Failed to stat file:", source.Path, err)
+				os.Exit(1)
+			}
+			labelSets = append(labelSets, source.Labels)
+		}
+		if *bindQueryStateFile != "" && *inputMode == "file" {
+			state, err = inputs.LoadStateFile(*bindQueryStateFile)
+			if err != nil {
+				log.Errorln("Failed to read state file:", *bindQueryStateFile, err)
+				os.Exit(1)
+			}
+		}
+	case "syslog":
+		labels, err := inputs.ParseLabels(*syslogLabels)
+		if err != nil {
+			log.Errorln("Invalid syslog labels:", err)
+			os.Exit(1)
+		}
+		labelSets = append(labelSets, labels)
+		if *syslogHostnameLabel != "" {
+			if err := inputs.ValidateLabelName(*syslogHostnameLabel); err != nil {
+				log.Errorln("Invalid syslog hostname label:", err)
+				os.Exit(1)
+			}
+			labelSets = append(labelSets, map[string]string{*syslogHostnameLabel: ""})
+		}
+		for _, spec := range *syslogListen {
+			listener, err := inputs.NewSyslogListener(spec, labels, *syslogHostnameLabel)
+			if err != nil {
+				log.Errorln("Failed to listen for syslog on", spec, err)
+				os.Exit(1)
+			}
+			syslogListeners = append(syslogListeners, listener)
+		}
+	case "dnstap":
+		labels, err := inputs.ParseLabels(*dnstapLabels)
+		if err != nil {
+			log.Errorln("Invalid dnstap labels:", err)
+			os.Exit(1)
+		}
+		labelSets = append(labelSets, labels)
+		if *dnstapIdentityLabel != "" {
+			if err := inputs.ValidateLabelName(*dnstapIdentityLabel); err != nil {
+				log.Errorln("Invalid dnstap identity label:", err)
+				os.Exit(1)
+			}
+			labelSets = append(labelSets, map[string]string{*dnstapIdentityLabel: ""})
+		}
+		dnstapListener, err = inputs.NewDnstapListener(*dnstapSocket, labels, *dnstapIdentityLabel, *dnstapMessage == "response")
+		if err != nil {
+			log.Errorln("Failed to listen for dnstap on", *dnstapSocket, err)
+			os.Exit(1)
+		}
+	}
+
 	var collectorsFilters []string
 	if *filterCollectors != "" {
 		collectorsFilters = strings.Split(*filterCollectors, ",")
@@ -199,45 +692,370 @@ func main() {
 		os.Exit(1)
 	}
 
-	var consumers []*chan string
+	matcher := util.LogMatcher{}
+	if *bindQueryPattern != util.LogMatcherDefaultPattern {
+		matcher.Regex = regexp.MustCompile(*bindQueryPattern)
+	}
+	if *bindQueryPatternLabels != "" && *inputMode != "dnstap" {
+		fieldLabels := make(map[string]string)
+		for _, name := range strings.Split(*bindQueryPatternLabels, ",") {
+			name = strings.TrimSpace(name)
+			if err := inputs.ValidateLabelName(name); err != nil {
+				log.Errorln("Invalid pattern label:", err)
+				os.Exit(1)
+			}
+			if !matcher.Captures(name) {
+				log.Errorf("Invalid pattern label: `%s` is not captured by --pattern", name)
+				os.Exit(1)
+			}
+			matcher.Labels = append(matcher.Labels, name)
+			fieldLabels[name] = ""
+		}
+		labelSets = append(labelSets, fieldLabels)
+	}
+
+	/* A replay counts into a registry of its own so only the collectors end
+	   up in the output, and counts every line before reading the next */
+	registry := prometheus.NewRegistry()
+	registerer := prometheus.DefaultRegisterer
+	bufferSize := *pipelineBufferSize
+	if *inputMode == "replay" {
+		matcher.ParseTimestamp = true
+		registerer = registry
+		bufferSize = 0
+	}
+
+	reloader := util.NewReloader(*metricsNamespace, "reload", "include/exclude lists")
+	/* Lease files change all the time, so they are reloaded and reported
+	   apart from the lists */
+	namesReloader := util.NewReloader(*metricsNamespace, "client_names_reload", "client name files")
+
+	/* Clients are named from the files first, and from DNS if they are in
+	   none of them */
+	reverseLookup := *bindQueryStatsReverseLookup || *bindQueryNamesReverseLookup || *bindQueryFlagsReverseLookup
+	var identity util.ClientIdentities
+	var resolver *util.ReverseResolver
+	if reverseLookup {
+		identity = util.ClientIdentities{}
+		for _, spec := range *clientNamesFiles {
+			names, err := util.NewClientNames(spec)
+			if err != nil {
+				log.Errorln("Error loading client names:", err)
+				os.Exit(1)
+			}
+			log.Infof("Naming %d clients from %s", names.Len(), spec)
+			identity = append(identity, names)
+			namesReloader.Add(spec, names.Reload, names.Files()...)
+		}
+	}
+	if reverseLookup && !*reverseLookupDNS && len(identity) == 0 {
+		log.Warnln("--no-reverse-lookup.dns is set without --clients.names.file, so clients will keep their addresses")
+	}
+	if reverseLookup && *reverseLookupDNS {
+		if *reverseLookupCacheSize < 1 || *reverseLookupWorkers < 1 {
+			log.Errorln("--reverse-lookup.cache-size and --reverse-lookup.workers must be at least 1")
+			os.Exit(1)
+		}
+		var client *util.PTRClient
+		if *reverseLookupServer != "" {
+			var err error
+			client, err = util.NewPTRClient(*reverseLookupServer, *reverseLookupTLSServerName, *reverseLookupTimeout)
+			if err != nil {
+				log.Errorln("Invalid --reverse-lookup.server:", err)
+				os.Exit(1)
+			}
+			log.Infoln("Making reverse lookups at", client.Server())
+			if *inputMode != "dnstap" && !matcher.Captures("port") {
+				log.Warnln("--pattern has no `port` group, so the exporter cannot recognise its own reverse lookups in the log and may count them as queries")
+			}
+		} else {
+			log.Warnln("Reverse lookups go through the system resolver, so the exporter cannot recognise its own lookups if that server's log is read. Set --reverse-lookup.server to ignore them")
+		}
+
+		/* Replays wait for each lookup instead, so no line is counted under
+		   the client IP just because its lookup had not completed yet */
+		workers := *reverseLookupWorkers
+		if *inputMode == "replay" {
+			workers = 0
+		}
+		resolver = util.NewReverseResolver(*metricsNamespace, client, *reverseLookupCacheSize, *reverseLookupTTL, *reverseLookupNegativeTTL, workers, *reverseLookupRate)
+		registerer.MustRegister(resolver)
+		identity = append(identity, resolver)
+	}
+
+	dispatcher := util.NewDispatcher(*metricsNamespace, &matcher, bufferSize, inputs.LabelNames(labelSets...), identity)
+	registerer.MustRegister(dispatcher)
+
+	var snapshot *util.Snapshot
+	if *snapshotFile != "" && *inputMode != "replay" {
+		snapshot = util.NewSnapshot(*snapshotFile)
+	}
+
+	/* Replays run on log time, which an expiry by wall clock time does not fit */
+	var expirer *util.SeriesExpirer
+	if *seriesTTL > 0 && *inputMode != "replay" {
+		expirer = util.NewSeriesExpirer(*metricsNamespace, *seriesTTL)
+	}
+	var limiter *util.SeriesLimiter
+	if *namesMaxSeries > 0 || *statsMaxSeries > 0 {
+		limiter = util.NewSeriesLimiter(*metricsNamespace)
+		registerer.MustRegister(limiter)
+	}
+
+	var clientMap *util.ClientMap
+	if *clientMapFile != "" {
+		var err error
+		clientMap, err = util.NewClientMap(*clientMapFile)
+		if err != nil {
+			log.Errorln("Failed to read the client map:", err)
+			os.Exit(1)
+		}
+		if err := checkClientMapLabels(clientMap, dispatcher.LabelNames()); err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		reloader.Add("client-map", clientMap.Reload, clientMap.Files()...)
+	} else if *bindQueryStatsCaptureClientMap || *bindQueryNamesCaptureClientMap {
+		log.Errorln("--stats.capture-client-map and --names.capture-client-map need --clients.map.file")
+		os.Exit(1)
+	}
+
 	if collectorsFilter.Enabled(filters.NamesCollector) {
-		matcher := util.LogMatcher{
-			ReverseLookup: *bindQueryNamesReverseLookup,
-			Regex:         regexp.MustCompile(*bindQueryPattern),
+		files, err := filterFiles(*bindQueryIncludeFile, *bindQueryExcludeFile, *bindQueryIncludeClientsFile, *bindQueryExcludeClientsFile, *bindQueryViewIncludeFiles, *bindQueryViewExcludeFiles)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
 		}
-		thisChannel := make(chan string)
-		consumers = append(consumers, &thisChannel)
-		namesCollector, err := collectors.NewNamesCollector(*metricsNamespace, &thisChannel, &matcher, *bindQueryIncludeFile, *bindQueryExcludeFile, *bindQueryIncludeClientsFile, *bindQueryExcludeClientsFile, *bindQueryNamesCaptureClient)
+		var aggregate func(string) string
+		switch *bindQueryNamesAggregate {
+		case "registrable":
+			suffixList, err := util.NewPublicSuffixList(*bindQueryNamesPublicSuffixList)
+			if err != nil {
+				log.Errorln("Failed to read the public suffix list:", err)
+				os.Exit(1)
+			}
+			aggregate = suffixList.RegistrableDomain
+			reloader.Add("public-suffix-list", suffixList.Reload, suffixList.Files()...)
+		case "depth":
+			if *bindQueryNamesAggregateDepth < 1 {
+				log.Errorln("--names.aggregate.depth must be at least 1")
+				os.Exit(1)
+			}
+			depth := *bindQueryNamesAggregateDepth
+			aggregate = func(name string) string { return util.TruncateName(name, depth) }
+		}
+		var namesClientMap *util.ClientMap
+		if *bindQueryNamesCaptureClientMap {
+			namesClientMap = clientMap
+		}
+		namesCollector, err := collectors.NewNamesCollector(*metricsNamespace, dispatcher, files, aggregate, namesClientMap, *bindQueryNamesTopK, expirer, limiter, *namesMaxSeries, *bindQueryNamesCaptureClient, *bindQueryNamesCaptureView, *bindQueryNamesReverseLookup)
 		if err != nil {
 			log.Error(err)
 			os.Exit(1)
 		}
-		prometheus.MustRegister(namesCollector)
+		registerer.MustRegister(namesCollector)
+		if snapshot != nil {
+			snapshot.Register(namesCollector.Counters())
+		}
+		reloader.Add("names", namesCollector.Reload, namesCollector.Files()...)
 	}
 	if collectorsFilter.Enabled(filters.StatsCollector) {
-		matcher := util.LogMatcher{
-			ReverseLookup: *bindQueryStatsReverseLookup,
-			Regex:         regexp.MustCompile(*bindQueryPattern),
-		}
-		thisChannel := make(chan string)
-		consumers = append(consumers, &thisChannel)
-		statsCollector := collectors.NewStatsCollector(*metricsNamespace, &thisChannel, &matcher, *bindQueryStatsCaptureClient)
-		prometheus.MustRegister(statsCollector)
-	}
-
-	go func(*[]*chan string) {
-		info := &tail.SeekInfo{Offset: fi.Size(), Whence: 0}
-		t, _ := tail.TailFile(*bindQueryLogFile, tail.Config{Follow: true, ReOpen: true, Location: info})
-		for line := range t.Lines {
-			log.Debugln("Read: ", line)
-			for _, consumer := range consumers {
-				*consumer <- line.Text
+		files, err := filterFiles(*bindQueryStatsIncludeFile, *bindQueryStatsExcludeFile, *bindQueryStatsIncludeClientsFile, *bindQueryStatsExcludeClientsFile, *bindQueryStatsViewIncludeFiles, *bindQueryStatsViewExcludeFiles)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		var statsClientMap *util.ClientMap
+		if *bindQueryStatsCaptureClientMap {
+			statsClientMap = clientMap
+		}
+		statsCollector, err := collectors.NewStatsCollector(*metricsNamespace, dispatcher, files, statsClientMap, *bindQueryStatsTopK, expirer, limiter, *statsMaxSeries, *bindQueryStatsCaptureClient, *bindQueryStatsCaptureView, *bindQueryStatsReverseLookup)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		registerer.MustRegister(statsCollector)
+		if snapshot != nil {
+			snapshot.Register(statsCollector.Counters())
+		}
+		reloader.Add("stats", statsCollector.Reload, statsCollector.Files()...)
+	}
+	if collectorsFilter.Enabled(filters.FlagsCollector) {
+		flagsCollector := collectors.NewFlagsCollector(*metricsNamespace, dispatcher, *bindQueryFlagsCaptureClient, *bindQueryFlagsReverseLookup)
+		registerer.MustRegister(flagsCollector)
+		if snapshot != nil {
+			snapshot.Register(flagsCollector.Counters())
+		}
+	}
+	if collectorsFilter.Enabled(filters.GroupsCollector) {
+		if *bindQueryGroupsFile == "" {
+			log.Warnln("The Groups collector is disabled as --groups.file is not set")
+		} else {
+			groupsCollector, err := collectors.NewGroupsCollector(*metricsNamespace, dispatcher, *bindQueryGroupsFile, *bindQueryGroupsCaptureView)
+			if err != nil {
+				log.Error(err)
+				os.Exit(1)
 			}
+			registerer.MustRegister(groupsCollector)
+			if snapshot != nil {
+				snapshot.Register(groupsCollector.Counters())
+			}
+			reloader.Add("groups", groupsCollector.Reload, groupsCollector.Files()...)
 		}
-	}(&consumers)
+	}
+
+	if collectorsFilter.Enabled(filters.UniqueCollector) {
+		byType, byEntry, err := parseUniqueBy(*uniqueBy)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		var uniqueClientMap *util.ClientMap
+		if *uniqueCaptureClientMap {
+			if clientMap == nil {
+				log.Errorln("--unique.capture-client-map needs --clients.map.file")
+				os.Exit(1)
+			}
+			uniqueClientMap = clientMap
+		}
+		files := collectors.FilterFiles{Include: *uniqueIncludeFile, Exclude: *uniqueExcludeFile}
+		uniqueCollector, err := collectors.NewUniqueCollector(*metricsNamespace, dispatcher, files, *uniqueWindow, byType, byEntry, uniqueClientMap, *uniqueCaptureView)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		registerer.MustRegister(uniqueCollector)
+		reloader.Add("unique", uniqueCollector.Reload, uniqueCollector.Files()...)
+	}
+
+	if *inputMode == "replay" {
+		os.Exit(replay(&matcher, dispatcher, sources, registry))
+	}
+
+	if snapshot != nil {
+		if err := snapshot.Restore(); err != nil {
+			log.Errorln("Failed to restore counters from snapshot:", *snapshotFile, err)
+			os.Exit(1)
+		}
+		go func() {
+			for range time.Tick(*snapshotInterval) {
+				if err := snapshot.Save(); err != nil {
+					log.Errorln("Failed to write snapshot:", *snapshotFile, err)
+				}
+			}
+		}()
+	}
+
+	if expirer != nil {
+		prometheus.MustRegister(expirer)
+		expirer.Start()
+	}
+	if limiter != nil {
+		limiter.Start()
+	}
+
+	prometheus.MustRegister(reloader, namesReloader)
+	if err := reloader.Watch(); err != nil {
+		log.Errorln("Failed to watch the include/exclude lists for changes:", err)
+		os.Exit(1)
+	}
+	if err := namesReloader.Watch(); err != nil {
+		log.Errorln("Failed to watch the client name files for changes:", err)
+		os.Exit(1)
+	}
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			log.Infoln("Received SIGHUP - reloading the include/exclude lists")
+			if err := reloader.Reload(); err != nil {
+				log.Errorln("Failed to reload:", err)
+			}
+			if err := namesReloader.Reload(); err != nil {
+				log.Errorln("Failed to reload the client names:", err)
+			}
+		}
+	}()
+
+	closers := []io.Closer{reloader, namesReloader}
+	for _, source := range sources {
+		log.Infoln("Watching", source.Path, source.Labels)
+		tailer := inputs.NewFileTailer(source, state)
+		closers = append(closers, tailer)
+		go func(source inputs.FileSource, tailer *inputs.FileTailer) {
+			if err := tailer.Serve(dispatcher); err != nil {
+				log.Errorln("Failed to tail file:", source.Path, err)
+			}
+		}(source, tailer)
+	}
+	for _, listener := range syslogListeners {
+		log.Infoln("Receiving syslog on", listener.Addr())
+		closers = append(closers, listener)
+		go func(listener *inputs.SyslogListener) {
+			if err := listener.Serve(dispatcher); err != nil {
+				log.Errorln("Failed to receive syslog on", listener.Addr(), err)
+			}
+		}(listener)
+	}
+	if dnstapListener != nil {
+		log.Infoln("Receiving dnstap on", dnstapListener.Addr())
+		closers = append(closers, dnstapListener)
+		go func() {
+			if err := dnstapListener.Serve(dispatcher); err != nil {
+				log.Errorln("Failed to receive dnstap on", dnstapListener.Addr(), err)
+			}
+		}()
+	}
+
+	if resolver != nil {
+		closers = append(closers, resolver)
+	}
+
+	if state != nil {
+		go func() {
+			for range time.Tick(*bindQueryStateInterval) {
+				if err := state.Save(); err != nil {
+					log.Errorln("Failed to write state file:", *bindQueryStateFile, err)
+				}
+			}
+		}()
+	}
+
+	if state != nil || snapshot != nil {
+		/* Stop reading and let the collectors count everything already read
+		   before the final save, so the positions and counters agree */
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			sig := <-signals
+			log.Infoln("Received", sig, "- saving and exiting")
+			for _, closer := range closers {
+				closer.Close()
+			}
+			dispatcher.Close()
+
+			status := 0
+			if state != nil {
+				if err := state.Save(); err != nil {
+					log.Errorln("Failed to write state file:", *bindQueryStateFile, err)
+					status = 1
+				}
+			}
+			if snapshot != nil {
+				if err := snapshot.Save(); err != nil {
+					log.Errorln("Failed to write snapshot:", *snapshotFile, err)
+					status = 1
+				}
+			}
+			os.Exit(status)
+		}()
+	}
 
 	handler := prometheusHandler()
 	http.Handle(*metricsPath, handler)
+	if *enableReload {
+		http.Handle("/-/reload", authenticated(util.ReloadHandler(reloader, namesReloader)))
+	}
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
              <head><title>BIND Query Exporter</title></head>
@@ -0,0 +1,148 @@
+package collectors
+
+import (
+	"strconv"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type FlagsCollector struct {
+	namespace       string
+	totalMetric     prometheus.CounterVec
+	recursionMetric prometheus.CounterVec
+	ednsMetric      prometheus.CounterVec
+	transportMetric prometheus.CounterVec
+	dnssecOKMetric  prometheus.CounterVec
+	signedMetric    prometheus.CounterVec
+	checkingMetric  prometheus.CounterVec
+	cookieMetric    prometheus.CounterVec
+	clientsMetric   prometheus.CounterVec
+}
+
+func NewFlagsCollector(namespace string, dispatcher *util.Dispatcher, captureClient bool, reverseLookup bool) *FlagsCollector {
+	config := newTailConfig(dispatcher, captureClient, false, nil)
+
+	counter := func(name string, help string, labels ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: "flags",
+				Name:      name,
+				Help:      help,
+			},
+			append(labels, config.labels...),
+		)
+	}
+
+	totalMetric := counter("total", "Total queries with a flags block in the query log")
+	recursionMetric := counter("recursion_desired", "Queries asking for recursion (+)")
+	ednsMetric := counter("total_by_edns", "Queries by EDNS version (E), 'none' without EDNS and 'unknown' when BIND does not log the version", "edns_version")
+	transportMetric := counter("total_by_transport", "Queries by transport (T for tcp, udp otherwise)", "transport")
+	dnssecOKMetric := counter("dnssec_ok", "Queries with the DNSSEC OK bit set (D)")
+	signedMetric := counter("signed", "Queries signed with TSIG or SIG(0) (S)")
+	checkingMetric := counter("checking_disabled", "Queries with checking disabled (C)")
+	cookieMetric := counter("total_by_cookie", "Queries by DNS cookie: 'none', 'client' for a client cookie only (K), 'server' for a valid server cookie (V)", "cookie")
+	clientsMetric := counter("by_client_and_cookie", "Queries by DNS cookie by client", "cookie", "client")
+
+	/* Gather our data on every event from the dispatcher */
+	dispatcher.Subscribe("flags", nil, reverseLookup, func(info util.LogMatch) {
+		flags, ok := util.ParseQueryFlags(info.QueryFlags)
+		if !ok {
+			return
+		}
+
+		totalMetric.WithLabelValues(config.labelValues(info)...).Add(1)
+		if flags.Recursion {
+			recursionMetric.WithLabelValues(config.labelValues(info)...).Add(1)
+		}
+
+		edns := "none"
+		if flags.EDNS {
+			edns = "unknown"
+			if flags.EDNSVersion >= 0 {
+				edns = strconv.Itoa(flags.EDNSVersion)
+			}
+		}
+		ednsMetric.WithLabelValues(config.labelValues(info, edns)...).Add(1)
+
+		transport := "udp"
+		if flags.TCP {
+			transport = "tcp"
+		}
+		transportMetric.WithLabelValues(config.labelValues(info, transport)...).Add(1)
+
+		if flags.DNSSECOK {
+			dnssecOKMetric.WithLabelValues(config.labelValues(info)...).Add(1)
+		}
+		if flags.Signed {
+			signedMetric.WithLabelValues(config.labelValues(info)...).Add(1)
+		}
+		if flags.CheckingDisabled {
+			checkingMetric.WithLabelValues(config.labelValues(info)...).Add(1)
+		}
+
+		cookie := "none"
+		if flags.ValidCookie {
+			cookie = "server"
+		} else if flags.Cookie {
+			cookie = "client"
+		}
+		cookieMetric.WithLabelValues(config.labelValues(info, cookie)...).Add(1)
+		if config.captureClient {
+			clientsMetric.WithLabelValues(config.labelValues(info, cookie, info.QueryClient)...).Add(1)
+		}
+	})
+
+	return &FlagsCollector{
+		namespace:       namespace,
+		totalMetric:     *totalMetric,
+		recursionMetric: *recursionMetric,
+		ednsMetric:      *ednsMetric,
+		transportMetric: *transportMetric,
+		dnssecOKMetric:  *dnssecOKMetric,
+		signedMetric:    *signedMetric,
+		checkingMetric:  *checkingMetric,
+		cookieMetric:    *cookieMetric,
+		clientsMetric:   *clientsMetric,
+	}
+}
+
+// The counter vectors whose values are kept in a snapshot
+func (c *FlagsCollector) Counters() map[string]*prometheus.CounterVec {
+	return map[string]*prometheus.CounterVec{
+		prometheus.BuildFQName(c.namespace, "flags", "total"):                &c.totalMetric,
+		prometheus.BuildFQName(c.namespace, "flags", "recursion_desired"):    &c.recursionMetric,
+		prometheus.BuildFQName(c.namespace, "flags", "total_by_edns"):        &c.ednsMetric,
+		prometheus.BuildFQName(c.namespace, "flags", "total_by_transport"):   &c.transportMetric,
+		prometheus.BuildFQName(c.namespace, "flags", "dnssec_ok"):            &c.dnssecOKMetric,
+		prometheus.BuildFQName(c.namespace, "flags", "signed"):               &c.signedMetric,
+		prometheus.BuildFQName(c.namespace, "flags", "checking_disabled"):    &c.checkingMetric,
+		prometheus.BuildFQName(c.namespace, "flags", "total_by_cookie"):      &c.cookieMetric,
+		prometheus.BuildFQName(c.namespace, "flags", "by_client_and_cookie"): &c.clientsMetric,
+	}
+}
+
+func (c *FlagsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.totalMetric.Collect(ch)
+	c.recursionMetric.Collect(ch)
+	c.ednsMetric.Collect(ch)
+	c.transportMetric.Collect(ch)
+	c.dnssecOKMetric.Collect(ch)
+	c.signedMetric.Collect(ch)
+	c.checkingMetric.Collect(ch)
+	c.cookieMetric.Collect(ch)
+	c.clientsMetric.Collect(ch)
+}
+
+func (c *FlagsCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.totalMetric.Describe(ch)
+	c.recursionMetric.Describe(ch)
+	c.ednsMetric.Describe(ch)
+	c.transportMetric.Describe(ch)
+	c.dnssecOKMetric.Describe(ch)
+	c.signedMetric.Describe(ch)
+	c.checkingMetric.Describe(ch)
+	c.cookieMetric.Describe(ch)
+	c.clientsMetric.Describe(ch)
+}
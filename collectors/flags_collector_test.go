@@ -0,0 +1,76 @@
+package collectors
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// A query log line for the client, name, type and flags
+func testLine(client string, name string, queryType string, flags string) string {
+	return fmt.Sprintf("05-Jun-2021 07:24:47.780 queries: info: client @0xadfc0030 %s#59542 (%s): query: %s IN %s %s (192.168.0.1)", client, name, name, queryType, flags)
+}
+
+// A dispatcher that reads lines with the built-in parser and hands each one
+// to the collectors before it returns
+func testDispatcher(labelNames ...string) *util.Dispatcher {
+	matcher := util.LogMatcher{}
+	return util.NewDispatcher("test", &matcher, 0, labelNames, nil)
+}
+
+func TestFlagsCollector(t *testing.T) {
+	dispatcher := testDispatcher()
+	collector := NewFlagsCollector("test", dispatcher, true, false)
+
+	for _, flags := range []string{"+E(0)TDK", "-S", "+C"} {
+		dispatcher.Dispatch(testLine("192.168.0.10", "bitnebula.com", "A", flags), nil)
+	}
+
+	expected := []struct {
+		name   string
+		value  float64
+		actual float64
+	}{
+		{"total", 3, testutil.ToFloat64(collector.totalMetric.WithLabelValues())},
+		{"recursion_desired", 2, testutil.ToFloat64(collector.recursionMetric.WithLabelValues())},
+		{"edns_version 0", 1, testutil.ToFloat64(collector.ednsMetric.WithLabelValues("0"))},
+		{"edns_version none", 2, testutil.ToFloat64(collector.ednsMetric.WithLabelValues("none"))},
+		{"transport tcp", 1, testutil.ToFloat64(collector.transportMetric.WithLabelValues("tcp"))},
+		{"transport udp", 2, testutil.ToFloat64(collector.transportMetric.WithLabelValues("udp"))},
+		{"cookie client", 1, testutil.ToFloat64(collector.cookieMetric.WithLabelValues("client"))},
+		{"cookie none", 2, testutil.ToFloat64(collector.cookieMetric.WithLabelValues("none"))},
+		{"dnssec_ok", 1, testutil.ToFloat64(collector.dnssecOKMetric.WithLabelValues())},
+		{"signed", 1, testutil.ToFloat64(collector.signedMetric.WithLabelValues())},
+		{"checking_disabled", 1, testutil.ToFloat64(collector.checkingMetric.WithLabelValues())},
+		{"by_client_and_cookie", 1, testutil.ToFloat64(collector.clientsMetric.WithLabelValues("client", "192.168.0.10"))},
+	}
+	for _, e := range expected {
+		if e.actual != e.value {
+			t.Fatalf("Expected %s to be %f but found %f", e.name, e.value, e.actual)
+		}
+	}
+}
+
+func TestFlagsCollectorEDNSVersions(t *testing.T) {
+	dispatcher := testDispatcher()
+	collector := NewFlagsCollector("test", dispatcher, false, false)
+
+	/* Older BIND versions log E without the version */
+	dispatcher.Dispatch(testLine("192.168.0.10", "bitnebula.com", "A", "+E"), nil)
+	dispatcher.Dispatch(testLine("192.168.0.10", "bitnebula.com", "A", "+E(1)V"), nil)
+
+	if value := testutil.ToFloat64(collector.ednsMetric.WithLabelValues("unknown")); value != 1 {
+		t.Fatalf("Expected 1 query with an unknown EDNS version but found %f", value)
+	}
+	if value := testutil.ToFloat64(collector.ednsMetric.WithLabelValues("1")); value != 1 {
+		t.Fatalf("Expected 1 query with EDNS version 1 but found %f", value)
+	}
+	if value := testutil.ToFloat64(collector.cookieMetric.WithLabelValues("server")); value != 1 {
+		t.Fatalf("Expected 1 query with a server cookie but found %f", value)
+	}
+	if count := testutil.CollectAndCount(&collector.clientsMetric); count != 0 {
+		t.Fatalf("Expected no client series without capturing clients but found %d", count)
+	}
+}
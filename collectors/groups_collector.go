@@ -0,0 +1,131 @@
+package collectors
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+type GroupsCollector struct {
+	namespace   string
+	mapFile     string
+	groupMap    atomic.Value // *util.GroupMap, swapped by Reload
+	totalMetric *prometheus.CounterVec
+	typesMetric *prometheus.CounterVec
+}
+
+func NewGroupsCollector(namespace string, dispatcher *util.Dispatcher, mapFile string, captureView bool) (*GroupsCollector, error) {
+	config := newTailConfig(dispatcher, false, captureView, nil)
+
+	groupMap := &util.GroupMap{}
+	if mapFile != "" {
+		log.Infoln("Will count queries by the groups in the file ", mapFile)
+		var err error
+		groupMap, err = util.LoadGroupMap(mapFile)
+		if err != nil {
+			log.Errorln("Failed to use group file: ", mapFile, err)
+			return nil, err
+		}
+	}
+	groupLabels := groupMap.LabelNames()
+	for _, name := range groupLabels {
+		if name == "type" || containsLabel(config.labels, name) {
+			return nil, fmt.Errorf("the group label `%s` is already used by the Groups metrics", name)
+		}
+	}
+
+	totalMetric := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "groups",
+			Name:      "total",
+			Help:      "Queries per group of names. Queries for names in no group have empty group labels",
+		},
+		append(append([]string{}, groupLabels...), config.labels...),
+	)
+
+	typesMetric := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "groups",
+			Name:      "total_by_type",
+			Help:      "Queries per group of names by type of query",
+		},
+		append(append([]string{"type"}, groupLabels...), config.labels...),
+	)
+
+	c := &GroupsCollector{
+		namespace:   namespace,
+		mapFile:     mapFile,
+		totalMetric: totalMetric,
+		typesMetric: typesMetric,
+	}
+	c.groupMap.Store(groupMap)
+
+	/* Gather our data on every event from the dispatcher */
+	dispatcher.Subscribe("groups", nil, false, func(info util.LogMatch) {
+		groups := c.groupMap.Load().(*util.GroupMap).Match(info.QueryName)
+		totalMetric.WithLabelValues(config.labelValues(info, groups...)...).Add(1)
+		typesMetric.WithLabelValues(config.labelValues(info, append([]string{info.QueryType}, groups...)...)...).Add(1)
+	})
+
+	return c, nil
+}
+
+// Reads the group file again and swaps it in at once. The labels of the
+// metrics cannot change while running, so a file with other label names is
+// refused and the groups in use are kept.
+func (c *GroupsCollector) Reload() error {
+	if c.mapFile == "" {
+		return nil
+	}
+	groupMap, err := util.LoadGroupMap(c.mapFile)
+	if err != nil {
+		return err
+	}
+	current := c.groupMap.Load().(*util.GroupMap)
+	if !reflect.DeepEqual(groupMap.LabelNames(), current.LabelNames()) {
+		return fmt.Errorf("%s: the group labels changed from %v to %v, which needs a restart", c.mapFile, current.LabelNames(), groupMap.LabelNames())
+	}
+	c.groupMap.Store(groupMap)
+	return nil
+}
+
+// The group file, for watching it for changes
+func (c *GroupsCollector) Files() []string {
+	if c.mapFile == "" {
+		return nil
+	}
+	return []string{c.mapFile}
+}
+
+// The counter vectors whose values are kept in a snapshot
+func (c *GroupsCollector) Counters() map[string]*prometheus.CounterVec {
+	return map[string]*prometheus.CounterVec{
+		prometheus.BuildFQName(c.namespace, "groups", "total"):         c.totalMetric,
+		prometheus.BuildFQName(c.namespace, "groups", "total_by_type"): c.typesMetric,
+	}
+}
+
+func (c *GroupsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.totalMetric.Collect(ch)
+	c.typesMetric.Collect(ch)
+}
+
+func (c *GroupsCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.totalMetric.Describe(ch)
+	c.typesMetric.Describe(ch)
+}
+
+func containsLabel(labels []string, name string) bool {
+	for _, label := range labels {
+		if label == name {
+			return true
+		}
+	}
+	return false
+}
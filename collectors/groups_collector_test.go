@@ -0,0 +1,80 @@
+package collectors
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestGroupsCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := writeList(t, dir, "groups.csv", "# pattern,labels\n.pay.example.com,service=payments\n*.telemetry.example.net,category=telemetry\n.example.net,service=web,category=public\n")
+	dispatcher := testDispatcher()
+	collector, err := NewGroupsCollector("test", dispatcher, file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher.Dispatch(testLine("192.168.0.10", "api.pay.example.com", "A", "+"), nil)
+	dispatcher.Dispatch(testLine("192.168.0.10", "eu.telemetry.example.net", "A", "+"), nil)
+	dispatcher.Dispatch(testLine("192.168.0.10", "eu.telemetry.example.net", "AAAA", "+"), nil)
+	dispatcher.Dispatch(testLine("192.168.0.10", "bitnebula.com", "A", "+"), nil)
+
+	/* The labels are category, then service, and the first group setting a
+	   label wins */
+	if value := testutil.ToFloat64(collector.totalMetric.WithLabelValues("", "payments")); value != 1 {
+		t.Fatalf("Expected 1 query for payments but found %f", value)
+	}
+	if value := testutil.ToFloat64(collector.totalMetric.WithLabelValues("telemetry", "web")); value != 2 {
+		t.Fatalf("Expected 2 queries for telemetry of web but found %f", value)
+	}
+	if value := testutil.ToFloat64(collector.typesMetric.WithLabelValues("AAAA", "telemetry", "web")); value != 1 {
+		t.Fatalf("Expected 1 AAAA query for telemetry of web but found %f", value)
+	}
+	if value := testutil.ToFloat64(collector.totalMetric.WithLabelValues("", "")); value != 1 {
+		t.Fatalf("Expected 1 query for a name in no group but found %f", value)
+	}
+
+	/* A reload may change the groups but not their label names */
+	writeList(t, dir, "groups.csv", ".bitnebula.com,service=blog\n.example.net,category=public\n")
+	if err := collector.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.Dispatch(testLine("192.168.0.10", "bitnebula.com", "A", "+"), nil)
+	if value := testutil.ToFloat64(collector.totalMetric.WithLabelValues("", "blog")); value != 1 {
+		t.Fatalf("Expected 1 query for blog after the reload but found %f", value)
+	}
+
+	writeList(t, dir, "groups.csv", ".bitnebula.com,team=blog\n")
+	if err := collector.Reload(); err == nil {
+		t.Fatalf("Expected an error reloading a file with other label names")
+	}
+	dispatcher.Dispatch(testLine("192.168.0.10", "bitnebula.com", "A", "+"), nil)
+	if value := testutil.ToFloat64(collector.totalMetric.WithLabelValues("", "blog")); value != 2 {
+		t.Fatalf("Expected the groups in use to be kept after a failed reload but found %f", value)
+	}
+}
+
+func TestGroupsCollectorLabelClash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := writeList(t, dir, "groups.csv", ".example.com,site=nyc\n")
+	if _, err := NewGroupsCollector("test", testDispatcher("site"), file, false); err == nil {
+		t.Fatalf("Expected an error for a group label used by a source")
+	}
+	file = writeList(t, dir, "groups.csv", ".example.com,type=web\n")
+	if _, err := NewGroupsCollector("test", testDispatcher(), file, false); err == nil {
+		t.Fatalf("Expected an error for the type group label")
+	}
+}
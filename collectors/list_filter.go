@@ -0,0 +1,127 @@
+package collectors
+
+import (
+	"github.com/DRuggeri/bind_query_exporter/util"
+	"github.com/prometheus/common/log"
+)
+
+// The files a collector reads its include/exclude lists from. Empty paths
+// are not used.
+type FilterFiles struct {
+	Include        string
+	Exclude        string
+	IncludeClients string
+	ExcludeClients string
+	ViewInclude    map[string]string
+	ViewExclude    map[string]string
+}
+
+// Every list file, for watching them for changes
+func (f FilterFiles) Files() []string {
+	var files []string
+	for _, file := range []string{f.Include, f.Exclude, f.IncludeClients, f.ExcludeClients} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	for _, file := range f.ViewInclude {
+		files = append(files, file)
+	}
+	for _, file := range f.ViewExclude {
+		files = append(files, file)
+	}
+	return files
+}
+
+// The lists of a collector's dispatcher subscription. Collectors embed it to
+// be reloadable.
+type listFilter struct {
+	subscription string
+	dispatcher   *util.Dispatcher
+	files        FilterFiles
+}
+
+// Reads the name and client lists again and swaps them in at once. If any
+// list fails to load, the ones in use are kept.
+func (f *listFilter) Reload() error {
+	filter, err := f.load()
+	if err != nil {
+		return err
+	}
+	f.dispatcher.SetFilter(f.subscription, filter)
+	return nil
+}
+
+// Every list file, for watching them for changes
+func (f *listFilter) Files() []string {
+	return f.files.Files()
+}
+
+func (f *listFilter) load() (*util.LogFilter, error) {
+	filter := &util.LogFilter{}
+
+	if f.files.Include != "" {
+		log.Infoln("The", f.subscription, "collector will only count names that ARE in the file ", f.files.Include)
+		tmp, err := util.LoadNameList(f.files.Include)
+		if err != nil {
+			log.Errorln("Failed to use include file: ", f.files.Include, err)
+			return nil, err
+		}
+		filter.Include = tmp
+	}
+	if f.files.Exclude != "" {
+		log.Infoln("The", f.subscription, "collector will only count names that ARE NOT in the file ", f.files.Exclude)
+		tmp, err := util.LoadNameList(f.files.Exclude)
+		if err != nil {
+			log.Errorln("Failed to use exclude file: ", f.files.Exclude, err)
+			return nil, err
+		}
+		filter.Exclude = tmp
+	}
+
+	if f.files.IncludeClients != "" {
+		log.Infoln("The", f.subscription, "collector will only count queries by clients in the file ", f.files.IncludeClients)
+		tmp, err := util.LoadClientList(f.files.IncludeClients)
+		if err != nil {
+			log.Errorln("Failed to use include clients file: ", f.files.IncludeClients, err)
+			return nil, err
+		}
+		filter.IncludeClient = tmp
+	}
+	if f.files.ExcludeClients != "" {
+		log.Infoln("The", f.subscription, "collector will ignore queries by clients in the file ", f.files.ExcludeClients)
+		tmp, err := util.LoadClientList(f.files.ExcludeClients)
+		if err != nil {
+			log.Errorln("Failed to use exclude clients file: ", f.files.ExcludeClients, err)
+			return nil, err
+		}
+		filter.ExcludeClient = tmp
+	}
+
+	for view, viewIncludeFile := range f.files.ViewInclude {
+		log.Infoln("The", f.subscription, "collector will only count names in view", view, "that ARE in the file ", viewIncludeFile)
+		tmp, err := util.LoadNameList(viewIncludeFile)
+		if err != nil {
+			log.Errorln("Failed to use include file for view "+view+": ", viewIncludeFile, err)
+			return nil, err
+		}
+		if filter.ViewInclude == nil {
+			filter.ViewInclude = make(map[string]*util.NameList)
+		}
+		filter.ViewInclude[view] = tmp
+	}
+	for view, viewExcludeFile := range f.files.ViewExclude {
+		log.Infoln("The", f.subscription, "collector will only count names in view", view, "that ARE NOT in the file ", viewExcludeFile)
+		tmp, err := util.LoadNameList(viewExcludeFile)
+		if err != nil {
+			log.Errorln("Failed to use exclude file for view "+view+": ", viewExcludeFile, err)
+			return nil, err
+		}
+		if filter.ViewExclude == nil {
+			filter.ViewExclude = make(map[string]*util.NameList)
+		}
+		filter.ViewExclude[view] = tmp
+	}
+
+	return filter, nil
+}
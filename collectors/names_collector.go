@@ -1,147 +1,125 @@
 package collectors
 
 import (
-	"bufio"
-	"os"
-
 	"github.com/DRuggeri/bind_query_exporter/util"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
 )
 
 type NamesCollector struct {
+	listFilter
 	namespace   string
-	namesMetric *prometheus.CounterVec
-	totalMetric prometheus.Counter
+	namesMetric *util.LimitedCounterVec
+	namesTop    *topKVec // in place of namesMetric in top-K mode
+	totalMetric *prometheus.CounterVec
 }
 
-func NewNamesCollector(namespace string, sender *chan string, matcher *util.LogMatcher, includeFile string, excludeFile string, includeClientsFile string, excludeClientsFile string, captureClient bool) (*NamesCollector, error) {
-	config := tailConfig{
-		matcher:       matcher,
-		captureClient: captureClient,
+func NewNamesCollector(namespace string, dispatcher *util.Dispatcher, files FilterFiles, aggregate func(string) string, clientMap *util.ClientMap, topK int, expirer *util.SeriesExpirer, limiter *util.SeriesLimiter, maxSeries int, captureClient bool, captureView bool, reverseLookup bool) (*NamesCollector, error) {
+	config := newTailConfig(dispatcher, captureClient, captureView, clientMap)
+	c := &NamesCollector{
+		listFilter: listFilter{subscription: "names", dispatcher: dispatcher, files: files},
+		namespace:  namespace,
 	}
-
-	if includeFile != "" {
-		log.Infoln("Will only export names that ARE in the file ", includeFile)
-		tmp, err := makeList(includeFile)
-		if err != nil {
-			log.Errorln("Failed to use include file: ", includeFile, err)
-			return nil, err
-		}
-		matcher.Include = tmp
-	}
-	if excludeFile != "" {
-		log.Infoln("Will only export names that ARE NOT in the file ", excludeFile)
-		tmp, err := makeList(excludeFile)
-		if err != nil {
-			log.Errorln("Failed to use exclude file: ", excludeFile, err)
-			return nil, err
-		}
-		matcher.Exclude = tmp
+	filter, err := c.load()
+	if err != nil {
+		return nil, err
 	}
 
-	if includeClientsFile != "" {
-		log.Infoln("Will only export names that are queried by clients in the file ", includeClientsFile)
-		tmp, err := makeList(includeClientsFile)
-		if err != nil {
-			log.Errorln("Failed to use include clients file: ", includeClientsFile, err)
-			return nil, err
-		}
-		matcher.IncludeClient = tmp
+	namesLabels := []string{"name"}
+	namesHelp := "Queries per DNS name"
+	if captureClient {
+		namesLabels = append(namesLabels, "client")
+		namesHelp = "Queries per DNS name per client"
 	}
-	if excludeClientsFile != "" {
-		log.Infoln("Will ignore names that are queried by clients in the file ", excludeClientsFile)
-		tmp, err := makeList(excludeClientsFile)
-		if err != nil {
-			log.Errorln("Failed to use exclude file: ", excludeClientsFile, err)
-			return nil, err
+	namesLabels = append(namesLabels, config.labels...)
+	namesMetric := limiter.Wrap(expirer.Wrap(prometheus.BuildFQName(namespace, "names", "all"), prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "names",
+			Name:      "all",
+			Help:      namesHelp,
+		},
+		namesLabels,
+	), namesLabels), maxSeries)
+
+	/* Only the most queried names are kept in top-K mode */
+	var namesTop *topKVec
+	if topK > 0 {
+		labels := []string{"name"}
+		help := "Estimated queries for the most queried DNS names, and for all others as '__other__'"
+		if captureClient {
+			labels = append(labels, "client")
+			help = "Estimated queries for the most queried pairs of DNS name and client, and for all others as '__other__'"
 		}
-		matcher.ExcludeClient = tmp
-	}
-
-	var namesMetric *prometheus.CounterVec
-	if captureClient {
-		namesMetric = prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Namespace: namespace,
-				Subsystem: "names",
-				Name:      "all",
-				Help:      "Queries per DNS name per client",
-			},
-			[]string{"name", "client"},
-		)
-	} else {
-		namesMetric = prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Namespace: namespace,
-				Subsystem: "names",
-				Name:      "all",
-				Help:      "Queries per DNS name",
-			},
-			[]string{"name"},
-		)
+		namesTop = newTopKVec(namespace, "names", "top", help, append(labels, config.labels...), labels, topK)
 	}
 
-	totalMetric := prometheus.NewCounter(
+	totalMetric := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: namespace,
 			Subsystem: "names",
 			Name:      "total",
 			Help:      "Sum of all queries matched. If no include/exclude filter is present, this will match bind_query_stats_total in the stats collector.  It is initialized to 0 to support increment() detection.",
 		},
+		config.labels,
 	)
-	totalMetric.Add(0)
+	/* Series with source labels only come into existence on their first query */
+	if len(config.labels) == 0 {
+		totalMetric.WithLabelValues().Add(0)
+	}
 
-	/* Spin off a thread that will gather our data on every read from the file */
-	go func(sender *chan string, namesMetric *prometheus.CounterVec, totalMetric prometheus.Counter, config *tailConfig) {
-		for line := range *sender {
-			info := matcher.ExtractInfo(line)
-			if info.Matched {
-				totalMetric.Add(1)
-				if config.captureClient {
-					namesMetric.WithLabelValues(info.QueryName, info.QueryClient).Add(1)
-				} else {
-					namesMetric.WithLabelValues(info.QueryName).Add(1)
-				}
-			}
+	/* Gather our data on every event from the dispatcher */
+	dispatcher.Subscribe("names", filter, reverseLookup, func(info util.LogMatch) {
+		name := info.QueryName
+		if aggregate != nil {
+			name = aggregate(name)
 		}
-	}(sender, namesMetric, totalMetric, &config)
-
-	return &NamesCollector{
-		namespace:   namespace,
-		namesMetric: namesMetric,
-		totalMetric: totalMetric,
-	}, nil
-}
 
-func makeList(fileName string) (map[string]bool, error) {
-	result := make(map[string]bool)
-	file, err := os.Open(fileName)
-	if err != nil {
-		return nil, err
-	}
+		totalMetric.WithLabelValues(config.labelValues(info)...).Add(1)
+		var values []string
+		if config.captureClient {
+			values = config.labelValues(info, name, info.QueryClient)
+		} else {
+			values = config.labelValues(info, name)
+		}
+		if namesTop != nil {
+			namesTop.Add(values...)
+		} else {
+			namesMetric.WithLabelValues(values...).Add(1)
+		}
+	})
 
-	defer file.Close()
+	c.namesMetric = namesMetric
+	c.namesTop = namesTop
+	c.totalMetric = totalMetric
+	return c, nil
+}
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		log.Debugln("  ", scanner.Text())
-		result[scanner.Text()] = true
+// The counter vectors whose values are kept in a snapshot. The estimates of
+// top-K mode are not.
+func (c *NamesCollector) Counters() map[string]*prometheus.CounterVec {
+	counters := map[string]*prometheus.CounterVec{
+		prometheus.BuildFQName(c.namespace, "names", "total"): c.totalMetric,
 	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	if c.namesTop == nil {
+		counters[prometheus.BuildFQName(c.namespace, "names", "all")] = c.namesMetric.CounterVec
 	}
-
-	return result, nil
+	return counters
 }
 
 func (c *NamesCollector) Collect(ch chan<- prometheus.Metric) {
 	c.totalMetric.Collect(ch)
-	c.namesMetric.Collect(ch)
+	if c.namesTop != nil {
+		c.namesTop.Collect(ch)
+	} else {
+		c.namesMetric.Collect(ch)
+	}
 }
 
 func (c *NamesCollector) Describe(ch chan<- *prometheus.Desc) {
-	c.namesMetric.Describe(ch)
+	if c.namesTop != nil {
+		c.namesTop.Describe(ch)
+	} else {
+		c.namesMetric.Describe(ch)
+	}
 	c.totalMetric.Describe(ch)
 }
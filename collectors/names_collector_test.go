@@ -0,0 +1,128 @@
+package collectors
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// A query log line answered by a view. BIND leaves out the view for queries
+// answered by its _default view.
+func testViewLine(client string, name string, view string) string {
+	if view == "" {
+		return testLine(client, name, "A", "+")
+	}
+	return strings.Replace(testLine(client, name, "A", "+"), "): query: ", "): view "+view+": query: ", 1)
+}
+
+func TestNamesCollectorView(t *testing.T) {
+	dispatcher := testDispatcher()
+	collector, err := NewNamesCollector("test", dispatcher, FilterFiles{}, nil, nil, 0, nil, nil, 0, false, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher.Dispatch(testViewLine("192.168.0.10", "www.example.com", "internal"), nil)
+	dispatcher.Dispatch(testViewLine("192.168.0.11", "www.example.com", "internal"), nil)
+	dispatcher.Dispatch(testViewLine("203.0.113.7", "www.example.com", "external"), nil)
+	dispatcher.Dispatch(testViewLine("192.168.0.10", "bitnebula.com", ""), nil)
+
+	expected := `
+# HELP test_names_all Queries per DNS name
+# TYPE test_names_all counter
+test_names_all{name="bitnebula.com",view=""} 1
+test_names_all{name="www.example.com",view="external"} 1
+test_names_all{name="www.example.com",view="internal"} 2
+# HELP test_names_total Sum of all queries matched. If no include/exclude filter is present, this will match bind_query_stats_total in the stats collector.  It is initialized to 0 to support increment() detection.
+# TYPE test_names_total counter
+test_names_total{view=""} 1
+test_names_total{view="external"} 1
+test_names_total{view="internal"} 2
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNamesCollectorAggregate(t *testing.T) {
+	suffixList, err := util.NewPublicSuffixList("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"www.example.co.uk", "mail.example.co.uk", "foo.bar.example.com", "co.uk", "host.site.corp.internal"}
+
+	for _, test := range []struct {
+		aggregate func(string) string
+		expected  string
+	}{
+		{suffixList.RegistrableDomain, `
+test_names_all{name="co.uk"} 1
+test_names_all{name="corp.internal"} 1
+test_names_all{name="example.co.uk"} 2
+test_names_all{name="example.com"} 1
+`},
+		{func(name string) string { return util.TruncateName(name, 2) }, `
+test_names_all{name="co.uk"} 3
+test_names_all{name="corp.internal"} 1
+test_names_all{name="example.com"} 1
+`},
+	} {
+		dispatcher := testDispatcher()
+		collector, err := NewNamesCollector("test", dispatcher, FilterFiles{}, test.aggregate, nil, 0, nil, nil, 0, false, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range names {
+			dispatcher.Dispatch(testLine("192.168.0.10", name, "A", "+"), nil)
+		}
+
+		expected := `
+# HELP test_names_all Queries per DNS name
+# TYPE test_names_all counter
+` + test.expected
+		if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "test_names_all"); err != nil {
+			t.Fatal(err)
+		}
+		if value := testutil.ToFloat64(collector.totalMetric.WithLabelValues()); value != float64(len(names)) {
+			t.Fatalf("Expected every query in the total but found %f", value)
+		}
+	}
+}
+
+func TestNamesCollectorClientMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clientMap, err := util.NewClientMap(writeList(t, dir, "clients.map", "10.0.0.0/8 tenant=acme\n10.1.0.0/16 site=nyc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher := testDispatcher()
+	collector, err := NewNamesCollector("test", dispatcher, FilterFiles{}, nil, clientMap, 0, nil, nil, 0, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher.Dispatch(testLine("10.1.2.3", "www.example.com", "A", "+"), nil)
+	dispatcher.Dispatch(testLine("10.1.2.4", "www.example.com", "A", "+"), nil)
+	dispatcher.Dispatch(testLine("10.2.0.1", "www.example.com", "A", "+"), nil)
+	dispatcher.Dispatch(testLine("192.168.0.10", "bitnebula.com", "A", "+"), nil)
+
+	expected := `
+# HELP test_names_all Queries per DNS name
+# TYPE test_names_all counter
+test_names_all{name="bitnebula.com",site="unknown",tenant="unknown"} 1
+test_names_all{name="www.example.com",site="nyc",tenant="acme"} 2
+test_names_all{name="www.example.com",site="unknown",tenant="acme"} 1
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "test_names_all"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -6,25 +6,32 @@ import (
 )
 
 type StatCollector struct {
+	listFilter
 	namespace     string
-	statMetric    prometheus.Counter
+	statMetric    prometheus.CounterVec
 	typesMetric   prometheus.CounterVec
-	clientsMetric prometheus.CounterVec
+	clientsMetric *util.LimitedCounterVec
+	clientsTop    *topKVec // in place of clientsMetric in top-K mode
+	rcodesMetric  prometheus.CounterVec
+	latencyMetric prometheus.HistogramVec
 }
 
-func NewStatsCollector(namespace string, sender *chan string, matcher *util.LogMatcher, captureClient bool) *StatCollector {
-	config := tailConfig{
-		matcher:       matcher,
-		captureClient: captureClient,
+func NewStatsCollector(namespace string, dispatcher *util.Dispatcher, files FilterFiles, clientMap *util.ClientMap, topK int, expirer *util.SeriesExpirer, limiter *util.SeriesLimiter, maxSeries int, captureClient bool, captureView bool, reverseLookup bool) (*StatCollector, error) {
+	config := newTailConfig(dispatcher, captureClient, captureView, clientMap)
+	lists := listFilter{subscription: "stats", dispatcher: dispatcher, files: files}
+	filter, err := lists.load()
+	if err != nil {
+		return nil, err
 	}
 
-	statMetric := prometheus.NewCounter(
+	statMetric := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: namespace,
 			Subsystem: "stats",
 			Name:      "total",
 			Help:      "Total queries recieved",
 		},
+		config.labels,
 	)
 
 	typesMetric := prometheus.NewCounterVec(
@@ -34,49 +41,106 @@ func NewStatsCollector(namespace string, sender *chan string, matcher *util.LogM
 			Name:      "total_by_type",
 			Help:      "Total queries recieved by type of query",
 		},
-		[]string{"type"},
+		append([]string{"type"}, config.labels...),
 	)
 
-	clientsMetric := prometheus.NewCounterVec(
+	clientsLabels := append([]string{"type", "client"}, config.labels...)
+	clientsMetric := limiter.Wrap(expirer.Wrap(prometheus.BuildFQName(namespace, "stats", "by_client_and_type"), prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: namespace,
 			Subsystem: "stats",
 			Name:      "by_client_and_type",
 			Help:      "Total queries recieved by type of query by client",
 		},
-		[]string{"type", "client"},
+		clientsLabels,
+	), clientsLabels), maxSeries)
+
+	/* Only the busiest clients are kept in top-K mode */
+	var clientsTop *topKVec
+	if topK > 0 && captureClient {
+		clientsTop = newTopKVec(namespace, "stats", "top_clients", "Estimated queries by type of query for the busiest clients, and for all others as '__other__'", append([]string{"type", "client"}, config.labels...), []string{"client"}, topK)
+	}
+
+	rcodesMetric := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "stats",
+			Name:      "total_by_rcode",
+			Help:      "Total responses sent by response code. Only available with the dnstap input",
+		},
+		append([]string{"rcode"}, config.labels...),
+	)
+
+	latencyMetric := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "stats",
+			Name:      "response_seconds",
+			Help:      "Time between receiving a query and sending the response. Only available with the dnstap input",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 9),
+		},
+		config.labels,
 	)
 
-	/* Spin off a thread that will gather our data on every read from the file */
-	go func(sender *chan string, clientsMetric *prometheus.CounterVec, statMetric prometheus.Counter, typesMetric *prometheus.CounterVec, matcher *util.LogMatcher, config *tailConfig) {
-		for line := range *sender {
-			info := matcher.ExtractInfo(line)
-			if info.Matched {
-				statMetric.Add(1)
-				typesMetric.WithLabelValues(info.QueryType).Add(1)
-				if config.captureClient {
-					clientsMetric.WithLabelValues(info.QueryType, info.QueryClient).Add(1)
-				}
-			}
+	/* Gather our data on every event from the dispatcher */
+	dispatcher.Subscribe("stats", filter, reverseLookup, func(info util.LogMatch) {
+		statMetric.WithLabelValues(config.labelValues(info)...).Add(1)
+		typesMetric.WithLabelValues(config.labelValues(info, info.QueryType)...).Add(1)
+		if clientsTop != nil {
+			clientsTop.Add(config.labelValues(info, info.QueryType, info.QueryClient)...)
+		} else if config.captureClient {
+			clientsMetric.WithLabelValues(config.labelValues(info, info.QueryType, info.QueryClient)...).Add(1)
+		}
+		if info.ResponseCode != "" {
+			rcodesMetric.WithLabelValues(config.labelValues(info, info.ResponseCode)...).Add(1)
+		}
+		if info.ResponseLatency > 0 {
+			latencyMetric.WithLabelValues(config.labelValues(info)...).Observe(info.ResponseLatency.Seconds())
 		}
-	}(sender, clientsMetric, statMetric, typesMetric, matcher, &config)
+	})
 
 	return &StatCollector{
+		listFilter:    lists,
 		namespace:     namespace,
-		statMetric:    statMetric,
+		statMetric:    *statMetric,
 		typesMetric:   *typesMetric,
-		clientsMetric: *clientsMetric,
+		clientsMetric: clientsMetric,
+		clientsTop:    clientsTop,
+		rcodesMetric:  *rcodesMetric,
+		latencyMetric: *latencyMetric,
+	}, nil
+}
+
+// The counter vectors whose values are kept in a snapshot
+func (c *StatCollector) Counters() map[string]*prometheus.CounterVec {
+	return map[string]*prometheus.CounterVec{
+		prometheus.BuildFQName(c.namespace, "stats", "total"):              &c.statMetric,
+		prometheus.BuildFQName(c.namespace, "stats", "total_by_type"):      &c.typesMetric,
+		prometheus.BuildFQName(c.namespace, "stats", "by_client_and_type"): c.clientsMetric.CounterVec,
+		prometheus.BuildFQName(c.namespace, "stats", "total_by_rcode"):     &c.rcodesMetric,
 	}
 }
 
 func (c *StatCollector) Collect(ch chan<- prometheus.Metric) {
 	c.statMetric.Collect(ch)
 	c.typesMetric.Collect(ch)
-	c.clientsMetric.Collect(ch)
+	if c.clientsTop != nil {
+		c.clientsTop.Collect(ch)
+	} else {
+		c.clientsMetric.Collect(ch)
+	}
+	c.rcodesMetric.Collect(ch)
+	c.latencyMetric.Collect(ch)
 }
 
 func (c *StatCollector) Describe(ch chan<- *prometheus.Desc) {
 	c.statMetric.Describe(ch)
 	c.typesMetric.Describe(ch)
-	c.clientsMetric.Describe(ch)
+	if c.clientsTop != nil {
+		c.clientsTop.Describe(ch)
+	} else {
+		c.clientsMetric.Describe(ch)
+	}
+	c.rcodesMetric.Describe(ch)
+	c.latencyMetric.Describe(ch)
 }
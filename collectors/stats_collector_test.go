@@ -0,0 +1,150 @@
+package collectors
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Writes a list file into the directory and returns its path
+func writeList(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStatsCollectorFilters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := FilterFiles{
+		Include:        writeList(t, dir, "include.txt", ".example.com\n"),
+		Exclude:        writeList(t, dir, "exclude.txt", "health.example.com\n"),
+		ExcludeClients: writeList(t, dir, "exclude_clients.txt", "10.0.0.0/8\n"),
+	}
+	dispatcher := testDispatcher()
+	collector, err := NewStatsCollector("test", dispatcher, files, nil, 0, nil, nil, 0, true, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher.Dispatch(testLine("192.168.0.10", "www.example.com", "A", "+"), nil)
+	dispatcher.Dispatch(testLine("192.168.0.10", "www.example.com", "AAAA", "+"), nil)
+	dispatcher.Dispatch(testLine("192.168.0.10", "bitnebula.com", "A", "+"), nil)      // not included
+	dispatcher.Dispatch(testLine("192.168.0.10", "health.example.com", "A", "+"), nil) // excluded
+	dispatcher.Dispatch(testLine("10.0.0.1", "www.example.com", "A", "+"), nil)        // excluded client
+
+	if value := testutil.ToFloat64(collector.statMetric.WithLabelValues()); value != 2 {
+		t.Fatalf("Expected 2 queries to be counted but found %f", value)
+	}
+	if value := testutil.ToFloat64(collector.typesMetric.WithLabelValues("A")); value != 1 {
+		t.Fatalf("Expected 1 A query but found %f", value)
+	}
+	if value := testutil.ToFloat64(collector.clientsMetric.WithLabelValues("AAAA", "192.168.0.10")); value != 1 {
+		t.Fatalf("Expected 1 AAAA query by 192.168.0.10 but found %f", value)
+	}
+	if count := testutil.CollectAndCount(collector.clientsMetric); count != 2 {
+		t.Fatalf("Expected no series for the excluded client but found %d series", count)
+	}
+
+	/* The lists are swapped on reload, and kept when they fail to load */
+	writeList(t, dir, "exclude_clients.txt", "192.168.0.0/16\n")
+	if err := collector.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.Dispatch(testLine("192.168.0.10", "www.example.com", "A", "+"), nil)
+	dispatcher.Dispatch(testLine("10.0.0.1", "www.example.com", "A", "+"), nil)
+	if value := testutil.ToFloat64(collector.clientsMetric.WithLabelValues("A", "10.0.0.1")); value != 1 {
+		t.Fatalf("Expected 10.0.0.1 to be counted after the reload but found %f", value)
+	}
+
+	os.Remove(files.Include)
+	if err := collector.Reload(); err == nil {
+		t.Fatalf("Expected an error reloading a missing list")
+	}
+	dispatcher.Dispatch(testLine("10.0.0.1", "bitnebula.com", "A", "+"), nil)
+	if value := testutil.ToFloat64(collector.statMetric.WithLabelValues()); value != 3 {
+		t.Fatalf("Expected the lists in use to be kept after a failed reload but found %f queries", value)
+	}
+}
+
+func TestStatsCollectorListErrors(t *testing.T) {
+	files := FilterFiles{IncludeClients: "testdata/missing.txt"}
+	if _, err := NewStatsCollector("test", testDispatcher(), files, nil, 0, nil, nil, 0, false, false, false); err == nil {
+		t.Fatalf("Expected an error for a missing client list")
+	}
+}
+
+func TestStatsCollectorView(t *testing.T) {
+	dispatcher := testDispatcher()
+	collector, err := NewStatsCollector("test", dispatcher, FilterFiles{}, nil, 0, nil, nil, 0, true, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher.Dispatch(testViewLine("192.168.0.10", "www.example.com", "internal"), nil)
+	dispatcher.Dispatch(testViewLine("192.168.0.10", "mail.example.com", "internal"), nil)
+	dispatcher.Dispatch(testViewLine("203.0.113.7", "www.example.com", "external"), nil)
+	dispatcher.Dispatch(testViewLine("192.168.0.10", "bitnebula.com", ""), nil)
+
+	if value := testutil.ToFloat64(collector.statMetric.WithLabelValues("internal")); value != 2 {
+		t.Fatalf("Expected 2 queries in the internal view but found %f", value)
+	}
+	if value := testutil.ToFloat64(collector.typesMetric.WithLabelValues("A", "external")); value != 1 {
+		t.Fatalf("Expected 1 A query in the external view but found %f", value)
+	}
+	if value := testutil.ToFloat64(collector.clientsMetric.WithLabelValues("A", "192.168.0.10", "")); value != 1 {
+		t.Fatalf("Expected 1 query by 192.168.0.10 without a view but found %f", value)
+	}
+	if count := testutil.CollectAndCount(&collector.statMetric); count != 3 {
+		t.Fatalf("Expected a series per view but found %d series", count)
+	}
+}
+
+func TestStatsCollectorClientMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clientMap, err := util.NewClientMap(writeList(t, dir, "clients.map", "10.0.0.0/8 tenant=acme\n10.1.0.0/16 site=nyc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher := testDispatcher("instance")
+	collector, err := NewStatsCollector("test", dispatcher, FilterFiles{}, clientMap, 0, nil, nil, 0, true, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher.Dispatch(testLine("10.1.2.3", "www.example.com", "A", "+"), map[string]string{"instance": "ns1"})
+	dispatcher.Dispatch(testLine("10.2.0.1", "www.example.com", "A", "+"), map[string]string{"instance": "ns1"})
+	dispatcher.Dispatch(testLine("192.168.0.10", "www.example.com", "AAAA", "+"), map[string]string{"instance": "ns1"})
+
+	/* The client map labels come after the source labels */
+	if value := testutil.ToFloat64(collector.statMetric.WithLabelValues("ns1", "nyc", "acme")); value != 1 {
+		t.Fatalf("Expected 1 query from nyc of acme but found %f", value)
+	}
+	if value := testutil.ToFloat64(collector.statMetric.WithLabelValues("ns1", util.ClientMapUnknown, "acme")); value != 1 {
+		t.Fatalf("Expected 1 query of acme from an unknown site but found %f", value)
+	}
+	if value := testutil.ToFloat64(collector.typesMetric.WithLabelValues("AAAA", "ns1", util.ClientMapUnknown, util.ClientMapUnknown)); value != 1 {
+		t.Fatalf("Expected 1 AAAA query from an unmapped client but found %f", value)
+	}
+	if value := testutil.ToFloat64(collector.clientsMetric.WithLabelValues("A", "10.1.2.3", "ns1", "nyc", "acme")); value != 1 {
+		t.Fatalf("Expected 1 A query by 10.1.2.3 labelled with its site but found %f", value)
+	}
+	if count := testutil.CollectAndCount(&collector.statMetric); count != 3 {
+		t.Fatalf("Expected a series per site and tenant but found %d series", count)
+	}
+}
@@ -5,6 +5,48 @@ import (
 )
 
 type tailConfig struct {
-	matcher       *util.LogMatcher
 	captureClient bool
+	captureView   bool
+	clientMap     *util.ClientMap
+	labels        []string
+}
+
+func newTailConfig(dispatcher *util.Dispatcher, captureClient bool, captureView bool, clientMap *util.ClientMap) tailConfig {
+	config := tailConfig{
+		captureClient: captureClient,
+		captureView:   captureView,
+		clientMap:     clientMap,
+		labels:        dispatcher.LabelNames(),
+	}
+
+	/* Sources can not have a view label of their own, it is reserved */
+	if captureView {
+		config.labels = append(append([]string{}, config.labels...), "view")
+	}
+
+	/* The client map labels always come last */
+	if clientMap != nil {
+		config.labels = append(append([]string{}, config.labels...), clientMap.LabelNames()...)
+	}
+	return config
+}
+
+// Appends the values of the source labels carried by the event, and those of
+// the client map for its client, to the collector's own label values
+func (c *tailConfig) labelValues(info util.LogMatch, values ...string) []string {
+	labels := c.labels
+	if c.clientMap != nil {
+		labels = labels[:len(labels)-len(c.clientMap.LabelNames())]
+	}
+	for _, name := range labels {
+		if name == "view" && c.captureView {
+			values = append(values, info.QueryView)
+			continue
+		}
+		values = append(values, info.Labels[name])
+	}
+	if c.clientMap != nil {
+		values = append(values, c.clientMap.Lookup(info.Address())...)
+	}
+	return values
 }
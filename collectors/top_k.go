@@ -0,0 +1,122 @@
+package collectors
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The value of the key labels of the series that sums up everything outside
+// the top K, which no name or client address is expected to take.
+const topKOther = "__other__"
+
+// How many keys are monitored for every key exported. More keys cost memory
+// but make the estimates of the top K tighter.
+const topKCapacityFactor = 4
+
+// A topKVec stands in for a CounterVec whose label values are too many to
+// export. It exports the K most frequent label value combinations and the
+// error bound of each estimate. Everything else is summed up in one series
+// per combination of the labels that are not keys, such as the query type or
+// the source labels, with the key labels set to topKOther.
+type topKVec struct {
+	k         int
+	isKey     []bool
+	summary   *util.TopK
+	countDesc *prometheus.Desc
+	errorDesc *prometheus.Desc
+	lock      sync.Mutex
+	groups    map[string]float64 // queries by the values of the labels that are not keys
+}
+
+func newTopKVec(namespace string, subsystem string, name string, help string, labels []string, keyLabels []string, k int) *topKVec {
+	isKey := make([]bool, len(labels))
+	for i, label := range labels {
+		for _, key := range keyLabels {
+			if label == key {
+				isKey[i] = true
+			}
+		}
+	}
+
+	v := &topKVec{
+		k:       k,
+		isKey:   isKey,
+		summary: util.NewTopK(k * topKCapacityFactor),
+		countDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, name),
+			help,
+			labels, nil,
+		),
+		errorDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, name+"_error"),
+			"Upper bound of how much the estimate of "+prometheus.BuildFQName(namespace, subsystem, name)+" may be too high",
+			labels, nil,
+		),
+		groups: make(map[string]float64),
+	}
+	/* Without other labels there is a single other series, exported from the start */
+	if len(keyLabels) == len(labels) {
+		v.groups[""] = 0
+	}
+	return v
+}
+
+// The values of the labels that are not keys
+func (v *topKVec) group(values []string) string {
+	group := make([]string, 0, len(values))
+	for i, value := range values {
+		if !v.isKey[i] {
+			group = append(group, value)
+		}
+	}
+	return strings.Join(group, "\xff")
+}
+
+func (v *topKVec) Add(values ...string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.groups[v.group(values)]++
+	v.summary.Add(strings.Join(values, "\xff"))
+}
+
+func (v *topKVec) Collect(ch chan<- prometheus.Metric) {
+	v.lock.Lock()
+	entries, _ := v.summary.Top(v.k)
+	rest := make(map[string]float64, len(v.groups))
+	for group, count := range v.groups {
+		rest[group] = count
+	}
+	v.lock.Unlock()
+
+	for _, entry := range entries {
+		values := strings.Split(entry.Key, "\xff")
+		ch <- prometheus.MustNewConstMetric(v.countDesc, prometheus.GaugeValue, entry.Count, values...)
+		ch <- prometheus.MustNewConstMetric(v.errorDesc, prometheus.GaugeValue, entry.Error, values...)
+		rest[v.group(values)] -= entry.Count
+	}
+
+	for group, count := range rest {
+		/* Estimates may be above the true counts */
+		if count < 0 {
+			count = 0
+		}
+		values := make([]string, len(v.isKey))
+		groupValues := strings.Split(group, "\xff")
+		for i := range values {
+			if v.isKey[i] {
+				values[i] = topKOther
+			} else {
+				values[i], groupValues = groupValues[0], groupValues[1:]
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(v.countDesc, prometheus.GaugeValue, count, values...)
+	}
+}
+
+func (v *topKVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- v.countDesc
+	ch <- v.errorDesc
+}
@@ -0,0 +1,61 @@
+package collectors
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTopKVecCollect(t *testing.T) {
+	vec := newTopKVec("test", "names", "top", "Estimated queries", []string{"type", "name"}, []string{"name"}, 2)
+	/* Room for three keys, so the fourth takes over a counter */
+	vec.summary = util.NewTopK(3)
+
+	for i := 0; i < 5; i++ {
+		vec.Add("A", "bitnebula.com")
+	}
+	vec.Add("A", "example.com")
+	vec.Add("AAAA", "example.com")
+	vec.Add("A", "example.net")
+	vec.Add("A", "example.net")
+
+	/* example.net inherited the count of 1 of the key it replaced, and the
+	   one query left outside the top two is counted as other for its type */
+	expected := `
+# HELP test_names_top Estimated queries
+# TYPE test_names_top gauge
+test_names_top{name="bitnebula.com",type="A"} 5
+test_names_top{name="example.net",type="A"} 3
+test_names_top{name="__other__",type="A"} 0
+test_names_top{name="__other__",type="AAAA"} 1
+# HELP test_names_top_error Upper bound of how much the estimate of test_names_top may be too high
+# TYPE test_names_top_error gauge
+test_names_top_error{name="bitnebula.com",type="A"} 0
+test_names_top_error{name="example.net",type="A"} 1
+`
+	if err := testutil.CollectAndCompare(vec, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTopKVecCollectEmpty(t *testing.T) {
+	/* With only key labels, the other series is there from the start */
+	vec := newTopKVec("test", "names", "top", "Estimated queries", []string{"name"}, []string{"name"}, 10)
+
+	expected := `
+# HELP test_names_top Estimated queries
+# TYPE test_names_top gauge
+test_names_top{name="__other__"} 0
+`
+	if err := testutil.CollectAndCompare(vec, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+
+	/* Otherwise it waits for the first query of each type */
+	vec = newTopKVec("test", "stats", "top_clients", "Estimated queries", []string{"type", "client"}, []string{"client"}, 10)
+	if count := testutil.CollectAndCount(vec); count != 0 {
+		t.Fatalf("Expected no series but got %d", count)
+	}
+}
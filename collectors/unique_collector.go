@@ -0,0 +1,172 @@
+package collectors
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Sketches of 4 KiB with a standard error of 1.6%
+	uniquePrecision = 12
+	// The window slides by a sixth of its length at a time
+	uniqueSlots = 6
+)
+
+// Counts distinct clients and names over a sliding window with HyperLogLog
+// sketches, so the counts cost the same memory whether a thousand or a
+// million clients are querying
+type UniqueCollector struct {
+	listFilter
+	window        time.Duration
+	byType        bool
+	byEntry       bool
+	config        tailConfig
+	entries       atomic.Value // *util.NameList of the include file, swapped by Reload
+	lock          sync.Mutex
+	sketches      map[string]*uniqueSketches
+	latest        time.Time // of the last query, for replays on log time
+	clientsMetric *prometheus.Desc
+	namesMetric   *prometheus.Desc
+}
+
+type uniqueSketches struct {
+	values  []string
+	clients *util.WindowedHyperLogLog
+	names   *util.WindowedHyperLogLog
+}
+
+func NewUniqueCollector(namespace string, dispatcher *util.Dispatcher, files FilterFiles, window time.Duration, byType bool, byEntry bool, clientMap *util.ClientMap, captureView bool) (*UniqueCollector, error) {
+	/* Each slot of the window must span a second at least */
+	if window < uniqueSlots*time.Second {
+		return nil, fmt.Errorf("the window of the Unique collector must be at least %s", uniqueSlots*time.Second)
+	}
+	if byEntry && files.Include == "" {
+		return nil, errors.New("counting distinct clients and names by include-list entry needs an include file")
+	}
+
+	config := newTailConfig(dispatcher, false, captureView, clientMap)
+	var labels []string
+	if byType {
+		labels = append(labels, "type")
+	}
+	if byEntry {
+		labels = append(labels, "entry")
+	}
+	for _, name := range labels {
+		if containsLabel(config.labels, name) {
+			return nil, fmt.Errorf("the label `%s` of the Unique metrics is already used by a source or the client map", name)
+		}
+	}
+	labels = append(labels, config.labels...)
+
+	c := &UniqueCollector{
+		listFilter: listFilter{subscription: "unique", dispatcher: dispatcher, files: files},
+		window:     window,
+		byType:     byType,
+		byEntry:    byEntry,
+		config:     config,
+		sketches:   make(map[string]*uniqueSketches),
+	}
+	filter, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	c.entries.Store(filter.Include)
+
+	c.clientsMetric = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "unique", "clients"),
+		"Estimated number of distinct clients that queried within the window",
+		labels, nil,
+	)
+	c.namesMetric = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "unique", "names"),
+		"Estimated number of distinct DNS names queried within the window",
+		labels, nil,
+	)
+
+	/* Gather our data on every event from the dispatcher */
+	dispatcher.Subscribe("unique", filter, false, c.add)
+	return c, nil
+}
+
+func (c *UniqueCollector) add(info util.LogMatch) {
+	var values []string
+	if c.byType {
+		values = append(values, info.QueryType)
+	}
+	if c.byEntry {
+		entry, _ := c.entries.Load().(*util.NameList).MatchEntry(info.QueryName)
+		values = append(values, entry)
+	}
+	values = c.config.labelValues(info, values...)
+
+	at := info.Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	key := strings.Join(values, "\xff")
+	c.lock.Lock()
+	sketches, ok := c.sketches[key]
+	if !ok {
+		sketches = &uniqueSketches{
+			values:  values,
+			clients: util.NewWindowedHyperLogLog(c.window, uniqueSlots, uniquePrecision),
+			names:   util.NewWindowedHyperLogLog(c.window, uniqueSlots, uniquePrecision),
+		}
+		c.sketches[key] = sketches
+	}
+	if !info.Timestamp.IsZero() && at.After(c.latest) {
+		c.latest = at
+	}
+	sketches.clients.Add(info.Address(), at)
+	sketches.names.Add(strings.ToLower(strings.TrimSuffix(info.QueryName, ".")), at)
+	c.lock.Unlock()
+}
+
+// Reads the lists again and swaps them in at once. If any list fails to
+// load, the ones in use are kept.
+func (c *UniqueCollector) Reload() error {
+	filter, err := c.load()
+	if err != nil {
+		return err
+	}
+	c.entries.Store(filter.Include)
+	c.dispatcher.SetFilter(c.subscription, filter)
+	return nil
+}
+
+func (c *UniqueCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	/* Replays count on the time of the log rather than the clock */
+	now := time.Now()
+	if !c.latest.IsZero() {
+		now = c.latest
+	}
+
+	for key, sketches := range c.sketches {
+		clients := sketches.clients.Count(now)
+		names := sketches.names.Count(now)
+		if clients == 0 && names == 0 {
+			/* Nothing within the window, so forget about it */
+			delete(c.sketches, key)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.clientsMetric, prometheus.GaugeValue, clients, sketches.values...)
+		ch <- prometheus.MustNewConstMetric(c.namesMetric, prometheus.GaugeValue, names, sketches.values...)
+	}
+}
+
+func (c *UniqueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.clientsMetric
+	ch <- c.namesMetric
+}
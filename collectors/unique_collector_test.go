@@ -0,0 +1,83 @@
+package collectors
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUniqueCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unique")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := FilterFiles{Include: writeList(t, dir, "include.txt", ".example.com\n.example.net\n")}
+	dispatcher := testDispatcher()
+	collector, err := NewUniqueCollector("test", dispatcher, files, time.Hour, true, true, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher.Dispatch(testLine("192.168.0.10", "www.example.com", "A", "+"), nil)
+	dispatcher.Dispatch(testLine("192.168.0.11", "www.example.com", "A", "+"), nil)
+	dispatcher.Dispatch(testLine("192.168.0.11", "mail.example.com", "A", "+"), nil)
+	dispatcher.Dispatch(testLine("192.168.0.11", "MAIL.example.com.", "A", "+"), nil) // the same name
+	dispatcher.Dispatch(testLine("192.168.0.12", "www.example.net", "AAAA", "+"), nil)
+	dispatcher.Dispatch(testLine("192.168.0.13", "bitnebula.com", "A", "+"), nil) // not included
+
+	expected := `
+# HELP test_unique_clients Estimated number of distinct clients that queried within the window
+# TYPE test_unique_clients gauge
+test_unique_clients{entry=".example.com",type="A"} 2
+test_unique_clients{entry=".example.net",type="AAAA"} 1
+# HELP test_unique_names Estimated number of distinct DNS names queried within the window
+# TYPE test_unique_names gauge
+test_unique_names{entry=".example.com",type="A"} 2
+test_unique_names{entry=".example.net",type="AAAA"} 1
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUniqueCollectorErrors(t *testing.T) {
+	if _, err := NewUniqueCollector("test", testDispatcher(), FilterFiles{}, time.Hour, false, true, nil, false); err == nil {
+		t.Fatalf("Expected an error counting by entry without an include file")
+	}
+	for _, window := range []time.Duration{0, time.Second} {
+		if _, err := NewUniqueCollector("test", testDispatcher(), FilterFiles{}, window, false, false, nil, false); err == nil {
+			t.Fatalf("Expected an error for a window of %s", window)
+		}
+	}
+
+	/* The labels of the collector must not clash with those of a source */
+	dir, err := ioutil.TempDir("", "unique")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := FilterFiles{Include: writeList(t, dir, "include.txt", ".example.com\n")}
+	for _, label := range []string{"type", "entry"} {
+		_, err := NewUniqueCollector("test", testDispatcher(label), files, time.Hour, true, true, nil, false)
+		if err == nil || !strings.Contains(err.Error(), "`"+label+"`") {
+			t.Fatalf("Expected an error for a source label named %s but got %v", label, err)
+		}
+	}
+	clientMap, err := util.NewClientMap(writeList(t, dir, "clients.map", "10.0.0.0/8 entry=acme\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewUniqueCollector("test", testDispatcher(), files, time.Hour, false, true, clientMap, false); err == nil {
+		t.Fatalf("Expected an error for a client map label named entry")
+	}
+	if _, err := NewUniqueCollector("test", testDispatcher("entry"), files, time.Hour, true, false, nil, false); err != nil {
+		t.Fatalf("Expected a source label named entry to be allowed without counting by entry but got %s", err)
+	}
+}
@@ -7,8 +7,11 @@ import (
 )
 
 const (
-	NamesCollector = "Names"
-	StatsCollector = "Stats"
+	NamesCollector  = "Names"
+	StatsCollector  = "Stats"
+	FlagsCollector  = "Flags"
+	GroupsCollector = "Groups"
+	UniqueCollector = "Unique"
 )
 
 type CollectorsFilter struct {
@@ -24,6 +27,12 @@ func NewCollectorsFilter(filters []string) (*CollectorsFilter, error) {
 			collectorsEnabled[NamesCollector] = true
 		case StatsCollector:
 			collectorsEnabled[StatsCollector] = true
+		case FlagsCollector:
+			collectorsEnabled[FlagsCollector] = true
+		case GroupsCollector:
+			collectorsEnabled[GroupsCollector] = true
+		case UniqueCollector:
+			collectorsEnabled[UniqueCollector] = true
 		default:
 			return &CollectorsFilter{}, errors.New(fmt.Sprintf("Collector filter `%s` is not supported", collectorName))
 		}
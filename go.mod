@@ -5,10 +5,15 @@ require github.com/prometheus/common v0.26.0
 require gopkg.in/alecthomas/kingpin.v2 v2.2.6
 
 require (
-	github.com/fsnotify/fsnotify v1.6.0 // indirect
-	github.com/hpcloud/tail v1.0.0
-	gopkg.in/fsnotify.v1 v1.4.7 // indirect
-	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
+	github.com/dnstap/golang-dnstap v0.4.0
+	github.com/fsnotify/fsnotify v1.6.0
+	github.com/klauspost/compress v1.11.13
+	github.com/matttproud/golang_protobuf_extensions v1.0.1
+	github.com/miekg/dns v1.1.31
+	github.com/prometheus/client_model v0.2.0
+	golang.org/x/net v0.0.0-20200625001655-4c5254603344
+	google.golang.org/protobuf v1.26.0
+	gopkg.in/yaml.v2 v2.3.0
 )
 
 module github.com/DRuggeri/bind_query_exporter
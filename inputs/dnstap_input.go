@@ -0,0 +1,256 @@
+package inputs
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
+	"github.com/prometheus/common/log"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+)
+
+// How long a connecting server may take to complete the Frame Streams handshake
+const dnstapHandshakeTimeout = 10 * time.Second
+
+// Receives dnstap messages over a Frame Streams unix socket and turns each
+// client query into the same event a log line would produce, without any
+// log parsing. Messages about BIND's own recursion (RESOLVER_*) are ignored.
+type DnstapListener struct {
+	listener      net.Listener
+	labels        map[string]string
+	identityLabel string
+	onResponse    bool
+
+	lock  sync.Mutex
+	conns map[net.Conn]bool
+}
+
+// Creates the unix socket BIND will connect to. When onResponse is set,
+// events are produced from response messages, which carry the response code
+// and latency as well. Otherwise they are produced from query messages.
+// When identityLabel is set, the server identity BIND sends is added under
+// that label to the static labels.
+func NewDnstapListener(path string, labels map[string]string, identityLabel string, onResponse bool) (*DnstapListener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DnstapListener{
+		listener:      listener,
+		labels:        labels,
+		identityLabel: identityLabel,
+		onResponse:    onResponse,
+		conns:         make(map[net.Conn]bool),
+	}, nil
+}
+
+func (l *DnstapListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Receives messages until the listener is closed
+func (l *DnstapListener) Serve(dispatcher *util.Dispatcher) error {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		/* Tracked before the handshake, so Close can interrupt a server that
+		   never completes it */
+		l.lock.Lock()
+		l.conns[conn] = true
+		l.lock.Unlock()
+
+		go func(conn net.Conn) {
+			l.serveConn(conn, dispatcher)
+
+			l.lock.Lock()
+			delete(l.conns, conn)
+			l.lock.Unlock()
+			conn.Close()
+		}(conn)
+	}
+}
+
+func (l *DnstapListener) serveConn(conn net.Conn, dispatcher *util.Dispatcher) {
+	input, err := dnstap.NewFrameStreamInputTimeout(conn, true, dnstapHandshakeTimeout)
+	if err != nil {
+		log.Errorln("Failed dnstap handshake:", err)
+		return
+	}
+
+	frames := make(chan []byte, 64)
+	go func() {
+		input.ReadInto(frames)
+		close(frames)
+	}()
+	for frame := range frames {
+		l.handle(frame, dispatcher)
+	}
+}
+
+func (l *DnstapListener) Close() error {
+	l.lock.Lock()
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.lock.Unlock()
+	return l.listener.Close()
+}
+
+func (l *DnstapListener) handle(frame []byte, dispatcher *util.Dispatcher) {
+	info, identity, err := DecodeDnstap(frame, l.onResponse)
+	if err != nil {
+		log.Debugln("Ignoring dnstap message:", err)
+		return
+	}
+	if !info.Matched {
+		return
+	}
+
+	info.Labels = l.labels
+	if l.identityLabel != "" {
+		info.Labels = make(map[string]string, len(l.labels)+1)
+		for k, v := range l.labels {
+			info.Labels[k] = v
+		}
+		info.Labels[l.identityLabel] = identity
+	}
+	dispatcher.Send(info)
+}
+
+// Decodes one dnstap frame. The returned event is only Matched for client
+// (or authoritative) queries or responses, whichever onResponse selects.
+// The server identity is returned alongside.
+func DecodeDnstap(frame []byte, onResponse bool) (util.LogMatch, string, error) {
+	result := util.LogMatch{Matched: false}
+
+	dt := &dnstap.Dnstap{}
+	if err := proto.Unmarshal(frame, dt); err != nil {
+		return result, "", err
+	}
+	identity := string(dt.GetIdentity())
+
+	m := dt.GetMessage()
+	if dt.GetType() != dnstap.Dnstap_MESSAGE || m == nil {
+		return result, identity, nil
+	}
+
+	var wire []byte
+	switch m.GetType() {
+	case dnstap.Message_CLIENT_QUERY, dnstap.Message_AUTH_QUERY:
+		if onResponse {
+			return result, identity, nil
+		}
+		wire = m.GetQueryMessage()
+	case dnstap.Message_CLIENT_RESPONSE, dnstap.Message_AUTH_RESPONSE:
+		if !onResponse {
+			return result, identity, nil
+		}
+		wire = m.GetResponseMessage()
+	default:
+		return result, identity, nil
+	}
+
+	msg := &dns.Msg{}
+	if err := msg.Unpack(wire); err != nil {
+		return result, identity, fmt.Errorf("invalid DNS message: %s", err)
+	}
+	if len(msg.Question) == 0 {
+		return result, identity, fmt.Errorf("DNS message without a question")
+	}
+	question := msg.Question[0]
+
+	result.Matched = true
+	result.QueryClient = net.IP(m.GetQueryAddress()).String()
+	if m.QueryPort != nil {
+		result.QueryPort = strconv.FormatUint(uint64(m.GetQueryPort()), 10)
+	}
+	result.QueryName = strings.TrimSuffix(question.Name, ".")
+	if result.QueryName == "" {
+		result.QueryName = "."
+	}
+	result.QueryType = typeString(question.Qtype)
+	result.QueryProtocol = m.GetSocketProtocol().String()
+	result.QueryFlags = queryFlags(msg, m.GetSocketProtocol())
+
+	if onResponse {
+		if rcode, ok := dns.RcodeToString[msg.Rcode]; ok {
+			result.ResponseCode = rcode
+		} else {
+			result.ResponseCode = fmt.Sprintf("RCODE%d", msg.Rcode)
+		}
+		if m.QueryTimeSec != nil && m.ResponseTimeSec != nil {
+			queried := time.Unix(int64(m.GetQueryTimeSec()), int64(m.GetQueryTimeNsec()))
+			responded := time.Unix(int64(m.GetResponseTimeSec()), int64(m.GetResponseTimeNsec()))
+			if latency := responded.Sub(queried); latency >= 0 {
+				result.ResponseLatency = latency
+			}
+		}
+	}
+
+	return result, identity, nil
+}
+
+// Writes the flags block BIND would log for the message, such as +E(0)K, so
+// the Flags collector counts dnstap events like log lines. A response echoes
+// most flags of its query, but its cookie is the one the server sent back.
+// Whether a server cookie was valid (V) is not in the message.
+func queryFlags(msg *dns.Msg, protocol dnstap.SocketProtocol) string {
+	var b strings.Builder
+	if msg.RecursionDesired {
+		b.WriteByte('+')
+	} else {
+		b.WriteByte('-')
+	}
+	if msg.IsTsig() != nil || isSig0(msg) {
+		b.WriteByte('S')
+	}
+	opt := msg.IsEdns0()
+	if opt != nil {
+		fmt.Fprintf(&b, "E(%d)", opt.Version())
+	}
+	if protocol != dnstap.SocketProtocol_UDP {
+		b.WriteByte('T')
+	}
+	if opt != nil && opt.Do() {
+		b.WriteByte('D')
+	}
+	if msg.CheckingDisabled {
+		b.WriteByte('C')
+	}
+	if opt != nil {
+		for _, option := range opt.Option {
+			if option.Option() == dns.EDNS0COOKIE {
+				b.WriteByte('K')
+				break
+			}
+		}
+	}
+	return b.String()
+}
+
+// A SIG(0) signature is the last record of the additional section
+func isSig0(msg *dns.Msg) bool {
+	return len(msg.Extra) > 0 && msg.Extra[len(msg.Extra)-1].Header().Rrtype == dns.TypeSIG
+}
+
+// Names query types the way BIND does in its query log
+func typeString(qtype uint16) string {
+	if name, ok := dns.TypeToString[qtype]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", qtype)
+}
@@ -0,0 +1,206 @@
+package inputs
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+)
+
+func makeDnstapFrame(t *testing.T, messageType dnstap.Message_Type) []byte {
+	query := &dns.Msg{}
+	query.SetQuestion("bitnebula.com.", dns.TypeAAAA)
+	response := &dns.Msg{}
+	response.SetRcode(query, dns.RcodeNameError)
+
+	queryWire, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	responseWire, err := response.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	protocol := dnstap.SocketProtocol_TCP
+	dt := &dnstap.Dnstap{
+		Identity: []byte("ns1"),
+		Type:     dnstap.Dnstap_MESSAGE.Enum(),
+		Message: &dnstap.Message{
+			Type:             messageType.Enum(),
+			SocketProtocol:   &protocol,
+			QueryAddress:     net.ParseIP("192.168.0.123").To4(),
+			QueryPort:        proto.Uint32(59542),
+			QueryTimeSec:     proto.Uint64(1622877887),
+			QueryTimeNsec:    proto.Uint32(0),
+			ResponseTimeSec:  proto.Uint64(1622877887),
+			ResponseTimeNsec: proto.Uint32(2500000),
+			QueryMessage:     queryWire,
+			ResponseMessage:  responseWire,
+		},
+	}
+
+	frame, err := proto.Marshal(dt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestDecodeDnstap(t *testing.T) {
+	info, identity, err := DecodeDnstap(makeDnstapFrame(t, dnstap.Message_CLIENT_RESPONSE), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.Matched {
+		t.Fatalf("No match detected in client response")
+	}
+	if identity != "ns1" {
+		t.Fatalf(`Expected identity of ns1 but got '%s'`, identity)
+	}
+	if info.QueryClient != "192.168.0.123" {
+		t.Fatalf(`Expected client of 192.168.0.123 but got '%s'`, info.QueryClient)
+	}
+	if info.QueryPort != "59542" {
+		t.Fatalf(`Expected port of 59542 but got '%s'`, info.QueryPort)
+	}
+	if info.QueryName != "bitnebula.com" {
+		t.Fatalf(`Expected target name of bitnebula.com but got '%s'`, info.QueryName)
+	}
+	if info.QueryType != "AAAA" {
+		t.Fatalf(`Expected query type of AAAA but got '%s'`, info.QueryType)
+	}
+	if info.QueryProtocol != "TCP" {
+		t.Fatalf(`Expected protocol of TCP but got '%s'`, info.QueryProtocol)
+	}
+	if info.QueryFlags != "+T" {
+		t.Fatalf(`Expected flags of +T but got '%s'`, info.QueryFlags)
+	}
+	if info.ResponseCode != "NXDOMAIN" {
+		t.Fatalf(`Expected response code of NXDOMAIN but got '%s'`, info.ResponseCode)
+	}
+	if info.ResponseLatency != 2500*time.Microsecond {
+		t.Fatalf(`Expected latency of 2.5ms but got %s`, info.ResponseLatency)
+	}
+
+	/* Each query is counted from one message type only */
+	for _, test := range []struct {
+		messageType dnstap.Message_Type
+		onResponse  bool
+		matched     bool
+	}{
+		{dnstap.Message_CLIENT_QUERY, false, true},
+		{dnstap.Message_CLIENT_QUERY, true, false},
+		{dnstap.Message_CLIENT_RESPONSE, false, false},
+		{dnstap.Message_AUTH_QUERY, false, true},
+		{dnstap.Message_RESOLVER_QUERY, false, false},
+		{dnstap.Message_RESOLVER_RESPONSE, true, false},
+	} {
+		info, _, err := DecodeDnstap(makeDnstapFrame(t, test.messageType), test.onResponse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Matched != test.matched {
+			t.Fatalf("Expected match %t for %s with onResponse %t", test.matched, test.messageType, test.onResponse)
+		}
+	}
+}
+
+func TestDnstapQueryFlags(t *testing.T) {
+	edns := func(do bool, options ...dns.EDNS0) *dns.Msg {
+		msg := &dns.Msg{}
+		msg.SetQuestion("bitnebula.com.", dns.TypeA)
+		msg.SetEdns0(1232, do)
+		opt := msg.IsEdns0()
+		opt.Option = append(opt.Option, options...)
+		return msg
+	}
+	cookie := &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "24a5ac1223fc4f3d"}
+
+	plain := &dns.Msg{}
+	plain.SetQuestion("bitnebula.com.", dns.TypeA)
+	iterative := plain.Copy()
+	iterative.RecursionDesired = false
+	checking := edns(true, cookie)
+	checking.CheckingDisabled = true
+	signed := plain.Copy()
+	signed.SetTsig("key.", dns.HmacSHA256, 300, 1622877887)
+
+	for _, test := range []struct {
+		msg      *dns.Msg
+		protocol dnstap.SocketProtocol
+		flags    string
+	}{
+		{plain, dnstap.SocketProtocol_UDP, "+"},
+		{iterative, dnstap.SocketProtocol_TCP, "-T"},
+		{edns(false), dnstap.SocketProtocol_UDP, "+E(0)"},
+		{edns(false, cookie), dnstap.SocketProtocol_DOT, "+E(0)TK"},
+		{checking, dnstap.SocketProtocol_UDP, "+E(0)DCK"},
+		{signed, dnstap.SocketProtocol_UDP, "+S"},
+	} {
+		if flags := queryFlags(test.msg, test.protocol); flags != test.flags {
+			t.Fatalf("Expected flags of %s but got %s for %s", test.flags, flags, test.msg)
+		}
+		if _, ok := util.ParseQueryFlags(test.flags); !ok {
+			t.Fatalf("Expected %s to be understood by the Flags collector", test.flags)
+		}
+	}
+}
+
+func TestDnstapListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bind_query_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	matcher := util.NewLogMatcher()
+	dispatcher := util.NewDispatcher("test", &matcher, 10, []string{"server"}, nil)
+	events := subscribe(dispatcher)
+
+	listener, err := NewDnstapListener(filepath.Join(dir, "dnstap.sock"), nil, "server", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go listener.Serve(dispatcher)
+
+	/* A server that never completes the handshake must not hold up others */
+	stalled, err := net.Dial("unix", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+
+	output, err := dnstap.NewFrameStreamSockOutput(listener.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	output.SetFlushTimeout(10 * time.Millisecond)
+	go output.RunOutputLoop()
+	defer output.Close()
+
+	output.GetOutputChannel() <- makeDnstapFrame(t, dnstap.Message_CLIENT_QUERY)
+	output.GetOutputChannel() <- makeDnstapFrame(t, dnstap.Message_CLIENT_RESPONSE)
+
+	select {
+	case info := <-events:
+		if info.QueryName != "bitnebula.com" || info.ResponseCode != "NXDOMAIN" {
+			t.Fatalf("Expected NXDOMAIN response for bitnebula.com but got %+v", info)
+		}
+		if info.Labels["server"] != "ns1" {
+			t.Fatalf("Expected server label of ns1 but got %v", info.Labels)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for dnstap message")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package inputs
+
+import (
+	"os"
+	"syscall"
+)
+
+func fileIdentity(fi os.FileInfo) FileIdentity {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return FileIdentity{Device: uint64(st.Dev), Inode: uint64(st.Ino)}
+	}
+	return FileIdentity{}
+}
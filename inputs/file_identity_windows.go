@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package inputs
+
+import (
+	"os"
+)
+
+// Windows has no inode to persist. A zero identity always compares equal, so
+// resuming falls back to trusting the path and checking the size.
+func fileIdentity(fi os.FileInfo) FileIdentity {
+	return FileIdentity{}
+}
@@ -0,0 +1,343 @@
+package inputs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/log"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+)
+
+var labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Labels the collectors already use for their own purposes
+var reservedLabels = map[string]bool{
+	"name":         true,
+	"client":       true,
+	"type":         true,
+	"rcode":        true,
+	"le":           true,
+	"view":         true,
+	"edns_version": true,
+	"transport":    true,
+	"cookie":       true,
+	"collector":    true,
+}
+
+type FileSource struct {
+	Path   string
+	Labels map[string]string
+}
+
+// Parses --log values of the form PATH[;label=value[,label=value...]] where
+// PATH may be a glob pattern. Every file a pattern matches becomes its own
+// source carrying that pattern's labels.
+func ParseFileSources(specs []string) ([]FileSource, error) {
+	var sources []FileSource
+	seen := make(map[string]bool)
+
+	for _, spec := range specs {
+		pattern := spec
+		labels := make(map[string]string)
+		if i := strings.Index(spec, ";"); i >= 0 {
+			pattern = spec[:i]
+			var err error
+			labels, err = ParseLabels(spec[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid labels in log `%s`: %s", spec, err)
+			}
+		}
+
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid log pattern `%s`: %s", pattern, err)
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("No files match log `%s`", pattern)
+		}
+
+		for _, path := range paths {
+			if seen[path] {
+				return nil, fmt.Errorf("Log file `%s` is given more than once", path)
+			}
+			seen[path] = true
+			sources = append(sources, FileSource{Path: path, Labels: labels})
+		}
+	}
+
+	return sources, nil
+}
+
+// Parses a comma separated list of label=value pairs
+func ParseLabels(spec string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("`%s` is not in the form label=value", pair)
+		}
+		name := strings.TrimSpace(kv[0])
+		if err := ValidateLabelName(name); err != nil {
+			return nil, err
+		}
+		labels[name] = strings.TrimSpace(kv[1])
+	}
+	return labels, nil
+}
+
+// Checks that a source label name is usable alongside the collectors' own
+func ValidateLabelName(name string) error {
+	if !labelNameRegexp.MatchString(name) {
+		return fmt.Errorf("`%s` is not a valid label name", name)
+	}
+	if reservedLabels[name] {
+		return fmt.Errorf("Label `%s` is reserved by the collectors", name)
+	}
+	return nil
+}
+
+// Returns the sorted union of label names across the label sets of all
+// sources. Sources that do not set one of these labels export it with an
+// empty value.
+func LabelNames(labelSets ...map[string]string) []string {
+	names := make(map[string]bool)
+	for _, labels := range labelSets {
+		for name := range labels {
+			names[name] = true
+		}
+	}
+
+	var result []string
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// How often a followed file is checked for new data, rotation and truncation
+const filePollInterval = 250 * time.Millisecond
+
+// Follows one log file like `tail -F`, handing every complete line to the
+// dispatcher. It tracks the exact offset of each line so the position can be
+// saved to a StateFile and resumed after a restart.
+type FileTailer struct {
+	source FileSource
+	state  *StateFile
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// Creates a tailer for the source. With a nil state the file is followed
+// from its current end, as it always has been.
+func NewFileTailer(source FileSource, state *StateFile) *FileTailer {
+	return &FileTailer{
+		source: source,
+		state:  state,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Follows the file until Close is called
+func (t *FileTailer) Serve(dispatcher *util.Dispatcher) error {
+	defer close(t.done)
+
+	file, pos, err := t.resume(dispatcher)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+
+	reader := bufio.NewReader(file)
+	partial := ""
+	for {
+		partial, err = t.readLines(reader, &pos, partial, dispatcher)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-t.stop:
+			return nil
+		case <-time.After(filePollInterval):
+		}
+
+		fi, err := os.Stat(t.source.Path)
+		if err != nil {
+			/* Most likely in the middle of a rotation - keep reading the old file */
+			continue
+		}
+		current, err := file.Stat()
+		if err != nil {
+			return err
+		}
+
+		if !os.SameFile(fi, current) {
+			/* Rotated. Whatever was written to the old file before the rename
+			   still needs to be read. */
+			partial, err = t.readLines(reader, &pos, partial, dispatcher)
+			if err != nil {
+				return err
+			}
+			if partial != "" {
+				t.dispatch(partial, &pos, dispatcher)
+				partial = ""
+			}
+
+			log.Infoln("Log file", t.source.Path, "was rotated, reopening")
+			newFile, err := os.Open(t.source.Path)
+			if err != nil {
+				continue
+			}
+			newInfo, err := newFile.Stat()
+			if err != nil {
+				newFile.Close()
+				continue
+			}
+			file.Close()
+			file = newFile
+			reader.Reset(file)
+			pos = FilePosition{FileIdentity: fileIdentity(newInfo)}
+			t.state.Update(t.source.Path, pos)
+		} else if fi.Size() < pos.Offset+int64(len(partial)) {
+			log.Infoln("Log file", t.source.Path, "was truncated, reading from the start")
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			reader.Reset(file)
+			partial = ""
+			pos.Offset = 0
+			t.state.Update(t.source.Path, pos)
+		}
+	}
+}
+
+// Stops following the file. Once Close returns, no more lines are dispatched
+// and the tailer's position in the state is final.
+func (t *FileTailer) Close() error {
+	close(t.stop)
+	<-t.done
+	return nil
+}
+
+// Opens the file at the position saved in the state. If the file was rotated
+// while the exporter was down, the rest of the rotated file is read first.
+func (t *FileTailer) resume(dispatcher *util.Dispatcher) (*os.File, FilePosition, error) {
+	file, err := os.Open(t.source.Path)
+	if err != nil {
+		return nil, FilePosition{}, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, FilePosition{}, err
+	}
+	pos := FilePosition{FileIdentity: fileIdentity(fi)}
+
+	saved, ok := t.state.Position(t.source.Path)
+	switch {
+	case !ok:
+		pos.Offset = fi.Size()
+	case saved.FileIdentity == pos.FileIdentity:
+		pos.Offset = saved.Offset
+		if fi.Size() < saved.Offset {
+			log.Infoln("Log file", t.source.Path, "is shorter than the saved position, reading from the start")
+			pos.Offset = 0
+		}
+	default:
+		if rotated := findRotated(t.source.Path, saved.FileIdentity); rotated != "" {
+			log.Infoln("Catching up on rotated log file", rotated, "from offset", saved.Offset)
+			if err := t.catchUp(rotated, saved, dispatcher); err != nil {
+				log.Errorln("Failed to catch up on rotated log file", rotated, err)
+			}
+		} else {
+			log.Warnln("Could not find the rotated file for the saved position of", t.source.Path, "- queries logged while the exporter was down are lost")
+		}
+		pos.Offset = 0
+	}
+
+	if _, err := file.Seek(pos.Offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, FilePosition{}, err
+	}
+	t.state.Update(t.source.Path, pos)
+	return file, pos, nil
+}
+
+// Reads a rotated file from the saved position to its end. The state keeps
+// pointing at the rotated file meanwhile, so an interrupted catch up resumes.
+func (t *FileTailer) catchUp(path string, pos FilePosition, dispatcher *util.Dispatcher) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(pos.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	partial, err := t.readLines(bufio.NewReader(file), &pos, "", dispatcher)
+	if err != nil {
+		return err
+	}
+	if partial != "" {
+		t.dispatch(partial, &pos, dispatcher)
+	}
+	return nil
+}
+
+// Reads complete lines until the end of the file. Returns the incomplete
+// line left at the end, to be prepended to what is read next.
+func (t *FileTailer) readLines(reader *bufio.Reader, pos *FilePosition, partial string, dispatcher *util.Dispatcher) (string, error) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return partial + line, nil
+		}
+		if err != nil {
+			return partial + line, err
+		}
+		t.dispatch(partial+line, pos, dispatcher)
+		partial = ""
+	}
+}
+
+func (t *FileTailer) dispatch(line string, pos *FilePosition, dispatcher *util.Dispatcher) {
+	pos.Offset += int64(len(line))
+	line = strings.TrimRight(line, "\r\n")
+	log.Debugln("Read: ", line)
+	dispatcher.Dispatch(line, t.source.Labels)
+	t.state.Update(t.source.Path, *pos)
+}
+
+// Looks for the file with the given identity among the rotated versions of
+// path, as left behind by logrotate (queries.log.1, queries.log-20210605) or
+// BIND's own versions (queries.log.0)
+func findRotated(path string, identity FileIdentity) string {
+	if identity == (FileIdentity{}) {
+		return ""
+	}
+	candidates, _ := filepath.Glob(path + "*")
+	for _, candidate := range candidates {
+		if candidate == path {
+			continue
+		}
+		if fi, err := os.Stat(candidate); err == nil && fileIdentity(fi) == identity {
+			return candidate
+		}
+	}
+	return ""
+}
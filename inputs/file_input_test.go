@@ -0,0 +1,166 @@
+package inputs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+)
+
+func TestParseFileSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bind_query_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"internal.log", "external.log", "other.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sources, err := ParseFileSources([]string{
+		filepath.Join(dir, "*.log") + ";instance=ns1, source=bind",
+		filepath.Join(dir, "other.txt"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sources) != 3 {
+		t.Fatalf("Expected 3 sources but got %d", len(sources))
+	}
+	if sources[0].Labels["instance"] != "ns1" || sources[0].Labels["source"] != "bind" {
+		t.Fatalf("Expected glob labels instance=ns1,source=bind but got %v", sources[0].Labels)
+	}
+	if len(sources[2].Labels) != 0 {
+		t.Fatalf("Expected no labels on plain path but got %v", sources[2].Labels)
+	}
+
+	names := LabelNames(sources[0].Labels, sources[2].Labels)
+	if len(names) != 2 || names[0] != "instance" || names[1] != "source" {
+		t.Fatalf("Expected label names [instance source] but got %v", names)
+	}
+}
+
+func TestParseFileSourcesErrors(t *testing.T) {
+	for _, spec := range []string{
+		"/nonexistent/queries.log",
+		"/dev/null;client=foo",
+		"/dev/null;rcode=foo",
+		"/dev/null;le=0.1",
+		"/dev/null;view=internal",
+		"/dev/null;transport=foo",
+		"/dev/null;not a label=foo",
+		"/dev/null;novalue",
+	} {
+		if _, err := ParseFileSources([]string{spec}); err == nil {
+			t.Fatalf("Expected an error for `%s`", spec)
+		}
+	}
+}
+
+const tailLine = "05-Jun-2021 07:24:47.780 queries: info: client @0xadfc0030 192.168.0.123#59542 (%s): query: %s IN A + (192.168.0.456)\n"
+
+// Collects every event the dispatcher hands out
+func subscribe(dispatcher *util.Dispatcher) <-chan util.LogMatch {
+	events := make(chan util.LogMatch, 100)
+	dispatcher.Subscribe("test", nil, false, func(info util.LogMatch) { events <- info })
+	return events
+}
+
+func appendLines(t *testing.T, path string, names ...string) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for _, name := range names {
+		fmt.Fprintf(file, tailLine, name, name)
+	}
+}
+
+func expectNames(t *testing.T, events <-chan util.LogMatch, names ...string) {
+	for _, name := range names {
+		select {
+		case info := <-events:
+			if info.QueryName != name {
+				t.Fatalf(`Expected target name of %s but got '%s'`, name, info.QueryName)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", name)
+		}
+	}
+	select {
+	case info := <-events:
+		t.Fatalf("Unexpected extra event for %s", info.QueryName)
+	case <-time.After(2 * filePollInterval):
+	}
+}
+
+func TestFileTailerResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bind_query_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "queries.log")
+	stateFile := filepath.Join(dir, "state.json")
+
+	matcher := util.NewLogMatcher()
+	dispatcher := util.NewDispatcher("test", &matcher, 10, nil, nil)
+	events := subscribe(dispatcher)
+
+	/* First run: no saved state, so only lines written after start count */
+	appendLines(t, logFile, "old.example")
+	state, err := LoadStateFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tailer := NewFileTailer(FileSource{Path: logFile}, state)
+	go tailer.Serve(dispatcher)
+	time.Sleep(filePollInterval)
+	appendLines(t, logFile, "one.example")
+	expectNames(t, events, "one.example")
+	tailer.Close()
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	/* Down: lines are logged, then the file is rotated and more are logged */
+	appendLines(t, logFile, "two.example", "three.example")
+	if err := os.Rename(logFile, logFile+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, logFile, "four.example")
+
+	/* Second run: everything logged while down is read exactly once */
+	state, err = LoadStateFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tailer = NewFileTailer(FileSource{Path: logFile}, state)
+	go tailer.Serve(dispatcher)
+	expectNames(t, events, "two.example", "three.example", "four.example")
+
+	/* Rotation while running */
+	appendLines(t, logFile, "five.example")
+	if err := os.Rename(logFile, logFile+".2"); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, logFile+".2", "six.example")
+	appendLines(t, logFile, "seven.example")
+	expectNames(t, events, "five.example", "six.example", "seven.example")
+	tailer.Close()
+
+	pos, ok := state.Position(logFile)
+	fi, _ := os.Stat(logFile)
+	if !ok || pos.Offset != fi.Size() {
+		t.Fatalf("Expected saved offset %d but got %d", fi.Size(), pos.Offset)
+	}
+}
@@ -0,0 +1,83 @@
+package inputs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+)
+
+// Identifies a file independent of its name, so a rotated file can be
+// recognised after it has been renamed
+type FileIdentity struct {
+	Device uint64 `json:"device"`
+	Inode  uint64 `json:"inode"`
+}
+
+// How far into which file a followed path has been consumed. Offset always
+// points just past the last complete line handed to the dispatcher.
+type FilePosition struct {
+	FileIdentity
+	Offset int64 `json:"offset"`
+}
+
+// Keeps the position of every followed path and persists them to disk so a
+// restarted exporter can continue where the previous one stopped
+type StateFile struct {
+	path      string
+	lock      sync.Mutex
+	positions map[string]FilePosition
+}
+
+// Reads the state file at path. A missing file is not an error - it simply
+// means there is nothing to resume from yet.
+func LoadStateFile(path string) (*StateFile, error) {
+	state := &StateFile{
+		path:      path,
+		positions: make(map[string]FilePosition),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &state.positions); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *StateFile) Position(file string) (FilePosition, bool) {
+	if s == nil {
+		return FilePosition{}, false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	pos, ok := s.positions[file]
+	return pos, ok
+}
+
+func (s *StateFile) Update(file string, pos FilePosition) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	s.positions[file] = pos
+	s.lock.Unlock()
+}
+
+// Writes the current positions
+func (s *StateFile) Save() error {
+	s.lock.Lock()
+	data, err := json.MarshalIndent(s.positions, "", "  ")
+	s.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(s.path, data)
+}
@@ -0,0 +1,178 @@
+package inputs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/common/log"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+	file *os.File
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return z.file.Close()
+}
+
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m multiCloser) Close() error {
+	var err error
+	for _, closer := range m.closers {
+		if closeErr := closer.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Opens a log file for reading from the start. gzip, bzip2 and zstd files
+// are recognised by their content, not their name, and decompressed.
+func OpenLogFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(file)
+	magic, _ := reader.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return multiCloser{Reader: gz, closers: []io.Closer{gz, file}}, nil
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return multiCloser{Reader: bzip2.NewReader(reader), closers: []io.Closer{file}}, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(reader)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return zstdReadCloser{Decoder: zr, file: file}, nil
+	}
+	return multiCloser{Reader: reader, closers: []io.Closer{file}}, nil
+}
+
+// Orders sources oldest first by modification time, which puts rotated logs
+// (queries.log.3.gz, queries.log.2.gz, queries.log.1) before the live one
+func SortSourcesByAge(sources []FileSource) {
+	modTimes := make(map[string]time.Time)
+	for _, source := range sources {
+		if fi, err := os.Stat(source.Path); err == nil {
+			modTimes[source.Path] = fi.ModTime()
+		}
+	}
+	sort.SliceStable(sources, func(i, j int) bool {
+		return modTimes[sources[i].Path].Before(modTimes[sources[j].Path])
+	})
+}
+
+// Reads whole log files once instead of following them. Whenever the time
+// in the log crosses a multiple of the interval, sample is called with that
+// time, after every line logged before it has been counted and before any
+// line logged after it.
+type Replayer struct {
+	matcher    *util.LogMatcher
+	dispatcher *util.Dispatcher
+	interval   time.Duration
+	sample     func(time.Time) error
+	next       time.Time
+}
+
+// The dispatcher must not queue (a buffer size of 0) so every line is
+// counted by the time the next one is read. A nil sample function or a zero
+// interval disables sampling.
+func NewReplayer(matcher *util.LogMatcher, dispatcher *util.Dispatcher, interval time.Duration, sample func(time.Time) error) *Replayer {
+	return &Replayer{
+		matcher:    matcher,
+		dispatcher: dispatcher,
+		interval:   interval,
+		sample:     sample,
+	}
+}
+
+func (r *Replayer) Replay(source FileSource) error {
+	reader, err := OpenLogFile(source.Path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines++
+		info := r.matcher.Match(strings.TrimRight(scanner.Text(), "\r"), source.Labels)
+		if info.Matched {
+			if err := r.advance(info.Timestamp); err != nil {
+				return err
+			}
+		}
+		r.dispatcher.Send(info)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	log.Infoln("Replayed", lines, "lines from", source.Path)
+	return nil
+}
+
+// Takes the final sample covering the last lines replayed
+func (r *Replayer) Finish() error {
+	if r.sample == nil || r.interval <= 0 {
+		return nil
+	}
+	if r.next.IsZero() {
+		return errors.New("no timestamps found in the log lines to take samples at")
+	}
+	return r.sample(r.next)
+}
+
+func (r *Replayer) advance(ts time.Time) error {
+	if r.sample == nil || r.interval <= 0 || ts.IsZero() {
+		return nil
+	}
+
+	if r.next.IsZero() {
+		r.next = ts.Truncate(r.interval).Add(r.interval)
+		return nil
+	}
+	/* Every boundary is sampled, even in quiet periods, just like a scrape */
+	for !ts.Before(r.next) {
+		if err := r.sample(r.next); err != nil {
+			return err
+		}
+		r.next = r.next.Add(r.interval)
+	}
+	return nil
+}
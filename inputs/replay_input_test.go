@@ -0,0 +1,102 @@
+package inputs
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+)
+
+const replayLine = "05-Jun-2021 %s queries: info: client @0xadfc0030 192.168.0.123#59542 (%s): query: %s IN A + (192.168.0.456)\n"
+
+func writeReplayLog(t *testing.T, path string, compress func(io.Writer) io.WriteCloser, lines ...string) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var w io.Writer = file
+	if compress != nil {
+		cw := compress(file)
+		defer cw.Close()
+		w = cw
+	}
+	for i := 0; i+1 < len(lines); i += 2 {
+		fmt.Fprintf(w, replayLine, lines[i], lines[i+1], lines[i+1])
+	}
+}
+
+func TestReplayer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bind_query_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gzipped := filepath.Join(dir, "queries.log.2.gz")
+	writeReplayLog(t, gzipped, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"07:24:47.780", "a.example.com",
+		"07:24:59.000", "b.example.com",
+	)
+	zstded := filepath.Join(dir, "queries.log.1.zst")
+	writeReplayLog(t, zstded, func(w io.Writer) io.WriteCloser {
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return zw
+	},
+		"07:25:10.000", "c.example.com",
+	)
+	plain := filepath.Join(dir, "queries.log")
+	writeReplayLog(t, plain, nil,
+		"07:27:30.000", "d.example.com",
+	)
+
+	/* Oldest first, whatever order they are given in */
+	now := time.Now()
+	os.Chtimes(gzipped, now.Add(-2*time.Hour), now.Add(-2*time.Hour))
+	os.Chtimes(zstded, now.Add(-time.Hour), now.Add(-time.Hour))
+	sources := []FileSource{{Path: plain}, {Path: zstded}, {Path: gzipped}}
+	SortSourcesByAge(sources)
+	if sources[0].Path != gzipped || sources[2].Path != plain {
+		t.Fatalf("Expected sources oldest first but got %v", sources)
+	}
+
+	matcher := util.NewLogMatcher()
+	matcher.ParseTimestamp = true
+	dispatcher := util.NewDispatcher("test", &matcher, 0, nil, nil)
+	var names []string
+	dispatcher.Subscribe("test", nil, false, func(info util.LogMatch) { names = append(names, info.QueryName) })
+
+	samples := make(map[string]int)
+	replayer := NewReplayer(&matcher, dispatcher, time.Minute, func(at time.Time) error {
+		samples[at.Format("15:04")] = len(names)
+		return nil
+	})
+	for _, source := range sources {
+		if err := replayer.Replay(source); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := replayer.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) != 4 || names[0] != "a.example.com" || names[3] != "d.example.com" {
+		t.Fatalf("Expected the four names in log order but got %v", names)
+	}
+	expected := map[string]int{"07:25": 2, "07:26": 3, "07:27": 3, "07:28": 4}
+	if fmt.Sprint(samples) != fmt.Sprint(expected) {
+		t.Fatalf("Expected samples %v but got %v", expected, samples)
+	}
+}
@@ -0,0 +1,346 @@
+package inputs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/common/log"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+)
+
+// Larger than any sane syslog message. RFC5425 only requires 2048 octets.
+const syslogMaxMessage = 64 * 1024
+
+type SyslogMessage struct {
+	Hostname string
+	AppName  string
+	Message  string
+}
+
+// Receives syslog messages on one socket and hands their payload to the
+// dispatcher. Both RFC3164 and RFC5424 messages are understood. Stream sockets
+// accept octet-counted (RFC6587) and newline delimited framing.
+type SyslogListener struct {
+	labels        map[string]string
+	hostnameLabel string
+	localHostname string
+
+	packetConn net.PacketConn
+	listener   net.Listener
+
+	lock  sync.Mutex
+	conns map[net.Conn]bool
+}
+
+// Opens a listener for a spec such as udp://:514, tcp://127.0.0.1:601,
+// unix:///run/bind.sock (stream) or unixgram:///dev/log (datagram). When
+// hostnameLabel is set, the sending host is added under that label to the
+// static labels of every message.
+func NewSyslogListener(spec string, labels map[string]string, hostnameLabel string) (*SyslogListener, error) {
+	parts := strings.SplitN(spec, "://", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Syslog listen address `%s` is not in the form network://address", spec)
+	}
+	network, address := parts[0], parts[1]
+
+	l := &SyslogListener{
+		labels:        labels,
+		hostnameLabel: hostnameLabel,
+		conns:         make(map[net.Conn]bool),
+	}
+
+	var err error
+	switch network {
+	case "udp", "udp4", "udp6":
+		l.packetConn, err = net.ListenPacket(network, address)
+	case "unixgram":
+		l.localHostname, _ = os.Hostname()
+		if err := removeStaleSocket(address); err != nil {
+			return nil, err
+		}
+		l.packetConn, err = net.ListenPacket(network, address)
+	case "tcp", "tcp4", "tcp6":
+		l.listener, err = net.Listen(network, address)
+	case "unix":
+		l.localHostname, _ = os.Hostname()
+		if err := removeStaleSocket(address); err != nil {
+			return nil, err
+		}
+		l.listener, err = net.Listen(network, address)
+	default:
+		return nil, fmt.Errorf("Syslog network `%s` is not supported", network)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Removes the socket a previous run left behind, but nothing that is not a
+// socket, such as a mistyped path to a regular file
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("`%s` exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
+
+func (l *SyslogListener) Addr() net.Addr {
+	if l.packetConn != nil {
+		return l.packetConn.LocalAddr()
+	}
+	return l.listener.Addr()
+}
+
+// Receives messages until the listener is closed
+func (l *SyslogListener) Serve(dispatcher *util.Dispatcher) error {
+	if l.packetConn != nil {
+		return l.servePackets(dispatcher)
+	}
+
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return err
+		}
+		l.lock.Lock()
+		l.conns[conn] = true
+		l.lock.Unlock()
+
+		go func(conn net.Conn) {
+			if err := l.serveStream(conn, dispatcher); err != nil && err != io.EOF {
+				log.Debugln("Syslog connection from", conn.RemoteAddr(), "ended:", err)
+			}
+			l.lock.Lock()
+			delete(l.conns, conn)
+			l.lock.Unlock()
+			conn.Close()
+		}(conn)
+	}
+}
+
+func (l *SyslogListener) Close() error {
+	l.lock.Lock()
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.lock.Unlock()
+
+	if l.packetConn != nil {
+		return l.packetConn.Close()
+	}
+	return l.listener.Close()
+}
+
+func (l *SyslogListener) servePackets(dispatcher *util.Dispatcher) error {
+	buf := make([]byte, syslogMaxMessage)
+	for {
+		n, addr, err := l.packetConn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		/* A datagram may still carry several newline separated messages */
+		for _, raw := range bytes.Split(buf[:n], []byte("\n")) {
+			l.handle(raw, addr, dispatcher)
+		}
+	}
+}
+
+func (l *SyslogListener) serveStream(conn net.Conn, dispatcher *util.Dispatcher) error {
+	reader := bufio.NewReaderSize(conn, syslogMaxMessage)
+	for {
+		first, err := reader.Peek(1)
+		if err != nil {
+			return err
+		}
+
+		var raw []byte
+		if first[0] >= '0' && first[0] <= '9' {
+			/* Octet counting: MSG-LEN SP SYSLOG-MSG */
+			lenField, err := reader.ReadString(' ')
+			if err != nil {
+				return err
+			}
+			length, err := strconv.Atoi(strings.TrimSpace(lenField))
+			if err != nil || length <= 0 || length > syslogMaxMessage {
+				return fmt.Errorf("invalid octet count `%s`", lenField)
+			}
+			raw = make([]byte, length)
+			if _, err := io.ReadFull(reader, raw); err != nil {
+				return err
+			}
+		} else {
+			raw, err = reader.ReadBytes('\n')
+			if err != nil && len(raw) == 0 {
+				return err
+			}
+		}
+		l.handle(raw, conn.RemoteAddr(), dispatcher)
+	}
+}
+
+func (l *SyslogListener) handle(raw []byte, addr net.Addr, dispatcher *util.Dispatcher) {
+	raw = bytes.TrimRight(raw, "\r\n\x00")
+	if len(raw) == 0 {
+		return
+	}
+
+	msg, err := ParseSyslog(string(raw))
+	if err != nil {
+		log.Debugln("Ignoring syslog message:", err)
+		return
+	}
+	log.Debugln("Received: ", msg.Message)
+
+	labels := l.labels
+	if l.hostnameLabel != "" {
+		hostname := msg.Hostname
+		if hostname == "" {
+			hostname = senderHost(addr)
+		}
+		if hostname == "" {
+			/* Messages on a unix socket can only come from this machine */
+			hostname = l.localHostname
+		}
+		labels = make(map[string]string, len(l.labels)+1)
+		for k, v := range l.labels {
+			labels[k] = v
+		}
+		labels[l.hostnameLabel] = hostname
+	}
+	dispatcher.Dispatch(msg.Message, labels)
+}
+
+// The IP a message came from, for messages that do not name their host
+func senderHost(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// Parses a single RFC5424 or RFC3164 message. Anything after the header is
+// returned as the message, so the regular log matcher can be applied to it.
+func ParseSyslog(raw string) (SyslogMessage, error) {
+	msg := SyslogMessage{}
+
+	if !strings.HasPrefix(raw, "<") {
+		return msg, fmt.Errorf("missing priority in `%s`", raw)
+	}
+	end := strings.IndexByte(raw, '>')
+	if end < 2 || end > 4 {
+		return msg, fmt.Errorf("invalid priority in `%s`", raw)
+	}
+	if _, err := strconv.Atoi(raw[1:end]); err != nil {
+		return msg, fmt.Errorf("invalid priority in `%s`", raw)
+	}
+	rest := raw[end+1:]
+
+	if strings.HasPrefix(rest, "1 ") {
+		return parseRFC5424(rest[2:])
+	}
+	return parseRFC3164(rest)
+}
+
+// VERSION has been consumed, what's left is
+// TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID SP STRUCTURED-DATA [SP MSG]
+func parseRFC5424(rest string) (SyslogMessage, error) {
+	msg := SyslogMessage{}
+
+	fields := make([]string, 5)
+	for i := range fields {
+		sp := strings.IndexByte(rest, ' ')
+		if sp < 0 {
+			return msg, fmt.Errorf("truncated RFC5424 header")
+		}
+		fields[i] = rest[:sp]
+		rest = rest[sp+1:]
+	}
+	if fields[1] != "-" {
+		msg.Hostname = fields[1]
+	}
+	if fields[2] != "-" {
+		msg.AppName = fields[2]
+	}
+
+	/* STRUCTURED-DATA is either a nil value or one or more [elements] which
+	   may contain escaped \] inside quoted param values */
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else {
+		for strings.HasPrefix(rest, "[") {
+			i := 1
+			for ; i < len(rest); i++ {
+				if rest[i] == '\\' {
+					i++
+				} else if rest[i] == ']' {
+					break
+				}
+			}
+			if i >= len(rest) {
+				return msg, fmt.Errorf("unterminated structured data")
+			}
+			rest = rest[i+1:]
+		}
+	}
+
+	rest = strings.TrimPrefix(rest, " ")
+	msg.Message = strings.TrimPrefix(rest, "\xef\xbb\xbf")
+	return msg, nil
+}
+
+// Mmm dd hh:mm:ss HOSTNAME TAG: MSG - where local senders frequently leave
+// out the hostname
+func parseRFC3164(rest string) (SyslogMessage, error) {
+	msg := SyslogMessage{}
+
+	/* The timestamp is fixed width, with day padded by a space */
+	if len(rest) < 16 || rest[3] != ' ' || rest[6] != ' ' || rest[9] != ':' || rest[12] != ':' {
+		/* No usable header. Treat the whole thing as the message */
+		msg.Message = rest
+		return msg, nil
+	}
+	rest = rest[16:]
+
+	sp := strings.IndexByte(rest, ' ')
+	if sp < 0 {
+		msg.Message = rest
+		return msg, nil
+	}
+	token := rest[:sp]
+	if !strings.HasSuffix(token, ":") && !strings.Contains(token, "[") {
+		msg.Hostname = token
+		rest = rest[sp+1:]
+	}
+
+	if colon := strings.Index(rest, ": "); colon >= 0 && !strings.ContainsAny(rest[:colon], " ") {
+		tag := rest[:colon]
+		if bracket := strings.IndexByte(tag, '['); bracket >= 0 {
+			tag = tag[:bracket]
+		}
+		msg.AppName = tag
+		rest = rest[colon+2:]
+	}
+
+	msg.Message = rest
+	return msg, nil
+}
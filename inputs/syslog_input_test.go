@@ -0,0 +1,128 @@
+package inputs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DRuggeri/bind_query_exporter/util"
+)
+
+const syslogQuery = "client @0xadfc0030 192.168.0.123#59542 (bitnebula.com): query: bitnebula.com IN A + (192.168.0.456)"
+
+func TestParseSyslog(t *testing.T) {
+	tests := []struct {
+		raw      string
+		hostname string
+		appName  string
+	}{
+		{"<30>Jun  5 07:24:47 ns1 named[812]: " + syslogQuery, "ns1", "named"},
+		{"<30>Jun  5 07:24:47 named[812]: " + syslogQuery, "", "named"},
+		{"<30>Jun  5 07:24:47 named: " + syslogQuery, "", "named"},
+		{"<30>1 2021-06-05T07:24:47.780Z ns1.example.com named 812 - - " + syslogQuery, "ns1.example.com", "named"},
+		{"<30>1 2021-06-05T07:24:47.780Z ns1 named 812 - [meta sequenceId=\"1\" note=\"a\\]b\"][x@1 y=\"z\"] \xef\xbb\xbf" + syslogQuery, "ns1", "named"},
+		{"<30>1 2021-06-05T07:24:47.780Z - - - - - " + syslogQuery, "", ""},
+	}
+
+	for _, test := range tests {
+		msg, err := ParseSyslog(test.raw)
+		if err != nil {
+			t.Fatalf("Failed to parse `%s`: %s", test.raw, err)
+		}
+		if msg.Hostname != test.hostname {
+			t.Fatalf("Expected hostname '%s' but got '%s' for `%s`", test.hostname, msg.Hostname, test.raw)
+		}
+		if msg.AppName != test.appName {
+			t.Fatalf("Expected app name '%s' but got '%s' for `%s`", test.appName, msg.AppName, test.raw)
+		}
+		if msg.Message != syslogQuery {
+			t.Fatalf("Expected message '%s' but got '%s'", syslogQuery, msg.Message)
+		}
+	}
+
+	if _, err := ParseSyslog(syslogQuery); err == nil {
+		t.Fatalf("Expected an error for a message without priority")
+	}
+}
+
+func TestSyslogListener(t *testing.T) {
+	for _, network := range []string{"udp", "tcp"} {
+		matcher := util.NewLogMatcher()
+		dispatcher := util.NewDispatcher("test", &matcher, 10, []string{"instance", "hostname"}, nil)
+		events := subscribe(dispatcher)
+
+		listener, err := NewSyslogListener(network+"://127.0.0.1:0", map[string]string{"instance": "ns1"}, "hostname")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go listener.Serve(dispatcher)
+
+		conn, err := net.Dial(network, listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		/* One newline framed RFC3164 and one octet counted RFC5424 message */
+		rfc5424 := "<30>1 2021-06-05T07:24:47.780Z - named 812 - - " + syslogQuery
+		fmt.Fprintf(conn, "<30>Jun  5 07:24:47 ns1 named[812]: %s\n", syslogQuery)
+		if network == "tcp" {
+			fmt.Fprintf(conn, "%d %s", len(rfc5424), rfc5424)
+		} else {
+			fmt.Fprint(conn, rfc5424)
+		}
+
+		for _, hostname := range []string{"ns1", "127.0.0.1"} {
+			select {
+			case info := <-events:
+				if info.QueryName != "bitnebula.com" {
+					t.Fatalf(`Expected target name of bitnebula.com but got '%s'`, info.QueryName)
+				}
+				if info.Labels["instance"] != "ns1" || info.Labels["hostname"] != hostname {
+					t.Fatalf("Expected labels instance=ns1,hostname=%s over %s but got %v", hostname, network, info.Labels)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("Timed out waiting for syslog message over %s", network)
+			}
+		}
+
+		conn.Close()
+		listener.Close()
+	}
+}
+
+func TestSyslogListenerUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	/* A socket left behind by a previous run is replaced */
+	path := filepath.Join(dir, "syslog.sock")
+	listener, err := NewSyslogListener("unix://"+path, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	listener, err = NewSyslogListener("unix://"+path, nil, "")
+	if err != nil {
+		t.Fatalf("Expected a stale socket to be replaced but got %s", err)
+	}
+	listener.Close()
+
+	/* Anything else is left alone */
+	file := filepath.Join(dir, "queries.log")
+	if err := ioutil.WriteFile(file, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSyslogListener("unixgram://"+file, nil, ""); err == nil {
+		t.Fatalf("Expected an error listening on a regular file")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("Expected the regular file to be kept but got %s", err)
+	}
+}
@@ -0,0 +1,32 @@
+package util
+
+// A ClientIdentity names clients by their address for the client label, in
+// place of the address itself
+type ClientIdentity interface {
+	// Returns the name of the client address, or "" if it has none
+	Lookup(address string) string
+}
+
+// A chain of identities asked in turn, such as lease files before DNS. The
+// first name found wins.
+type ClientIdentities []ClientIdentity
+
+func (c ClientIdentities) Lookup(address string) string {
+	for _, identity := range c {
+		if name := identity.Lookup(address); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// Whether the query is an identity's own lookup, which must not be counted or
+// looked up in turn
+func (c ClientIdentities) Own(info LogMatch) bool {
+	for _, identity := range c {
+		if own, ok := identity.(interface{ Own(LogMatch) bool }); ok && own.Own(info) {
+			return true
+		}
+	}
+	return false
+}
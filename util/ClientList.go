@@ -0,0 +1,156 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// A ClientList matches clients against a list of entries, one of
+//
+//	192.168.0.123     that address
+//	10.20.0.0/16      every address in the range, IPv4 or IPv6
+//	printer.lan       a reverse name, with everything a NameList understands
+//
+// Addresses and ranges live in a tree of prefix bits per address family, so
+// a lookup never takes more than 32 or 128 steps however long the list is.
+type ClientList struct {
+	addresses prefixTree
+	names     *NameList
+	size      int
+}
+
+// A tree of address prefix bits per address family
+type prefixTree struct {
+	v4 prefixNode
+	v6 prefixNode
+}
+
+type prefixNode struct {
+	children [2]*prefixNode
+	terminal bool
+	labels   map[string]string // only used by a ClientMap
+}
+
+func NewClientList() *ClientList {
+	return &ClientList{names: NewNameList()}
+}
+
+// Reads a list with one entry per line. Blank lines and lines starting with
+// # are skipped.
+func LoadClientList(fileName string) (*ClientList, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := NewClientList()
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if err := list.Add(entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", fileName, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (l *ClientList) Add(entry string) error {
+	if strings.Contains(entry, "/") && !strings.HasPrefix(entry, "re:") {
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return err
+		}
+		ones, _ := network.Mask.Size()
+		l.addresses.insert(network.IP, ones)
+		l.size++
+		return nil
+	}
+
+	if ip := net.ParseIP(entry); ip != nil {
+		l.addresses.insert(ip, -1)
+		l.size++
+		return nil
+	}
+
+	if err := l.names.Add(entry); err != nil {
+		return err
+	}
+	l.size++
+	return nil
+}
+
+// The number of entries in the list
+func (l *ClientList) Len() int {
+	if l == nil {
+		return 0
+	}
+	return l.size
+}
+
+// Whether the client, an address or a reverse name, matches an entry
+func (l *ClientList) Match(client string) bool {
+	if l == nil {
+		return false
+	}
+
+	ip := net.ParseIP(client)
+	if ip == nil {
+		return l.names.Match(client)
+	}
+
+	matched := false
+	l.addresses.walk(ip, func(*prefixNode) { matched = true })
+	return matched
+}
+
+// Adds the first ones bits of the address, or all of them when ones is -1,
+// and returns the node they lead to
+func (t *prefixTree) insert(ip net.IP, ones int) *prefixNode {
+	node, bits := t.root(ip)
+	if ones < 0 {
+		ones = len(bits) * 8
+	}
+	for i := 0; i < ones; i++ {
+		bit := bits[i/8] >> (7 - uint(i%8)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &prefixNode{}
+		}
+		node = node.children[bit]
+	}
+	node.terminal = true
+	return node
+}
+
+// Calls visit for every prefix in the tree the address is in, from the
+// shortest to the longest
+func (t *prefixTree) walk(ip net.IP, visit func(*prefixNode)) {
+	node, bits := t.root(ip)
+	for i := 0; node != nil; i++ {
+		if node.terminal {
+			visit(node)
+		}
+		if i == len(bits)*8 {
+			return
+		}
+		node = node.children[bits[i/8]>>(7-uint(i%8))&1]
+	}
+}
+
+func (t *prefixTree) root(ip net.IP) (*prefixNode, net.IP) {
+	if v4 := ip.To4(); v4 != nil {
+		return &t.v4, v4
+	}
+	return &t.v6, ip.To16()
+}
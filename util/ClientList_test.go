@@ -0,0 +1,65 @@
+package util
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestClientList(t *testing.T) {
+	list := NewClientList()
+	for _, entry := range []string{
+		"192.168.0.123",
+		"10.20.0.0/16",
+		"2001:db8:1::/48",
+		"::1",
+		"printer.lan",
+		".probes.example.com",
+	} {
+		if err := list.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for client, expected := range map[string]bool{
+		"192.168.0.123":             true,
+		"192.168.0.124":             false,
+		"10.20.0.1":                 true,
+		"10.20.255.255":             true,
+		"10.21.0.1":                 false,
+		"::ffff:10.20.3.4":          true,
+		"2001:db8:1:ffff::53":       true,
+		"2001:db8:2::53":            false,
+		"::1":                       true,
+		"::2":                       false,
+		"printer.lan":               true,
+		"scanner.lan":               false,
+		"eu1.probes.example.com":    true,
+		"192.168.0.123.example.com": false,
+	} {
+		if matched := list.Match(client); matched != expected {
+			t.Fatalf("Expected %s to match %t but got %t", client, expected, matched)
+		}
+	}
+
+	for _, entry := range []string{"10.0.0.0/33", "10.0.0/8", "2001:db8::/129"} {
+		if err := NewClientList().Add(entry); err == nil {
+			t.Fatalf("Expected an error for `%s`", entry)
+		}
+	}
+
+	filter := &LogFilter{ExcludeClient: list}
+	if filter.MatchClient("10.20.0.1", "host.lan") || !filter.MatchClient("10.30.0.1", "host.lan") {
+		t.Fatalf("Expected the address or the name of a client to be excluded")
+	}
+}
+
+func BenchmarkClientList(b *testing.B) {
+	list := NewClientList()
+	for i := 0; i < 100000; i++ {
+		list.Add(fmt.Sprintf("10.%d.%d.0/24", i/256%256, i%256))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		list.Match("10.1.2.3")
+	}
+}
@@ -0,0 +1,166 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/common/model"
+)
+
+// The value of a client map label for clients no entry sets it for
+const ClientMapUnknown = "unknown"
+
+// A ClientMap labels clients by the network they are in, such as the site,
+// VLAN or tenant, from a file of entries like
+//
+//	10.0.0.0/8       tenant=acme
+//	10.1.0.0/16      site=nyc vlan=20
+//	2001:db8:1::/48  site=nyc
+//
+// A client takes the labels of every entry it is in, those of the longer
+// prefixes first, so 10.1.2.3 above is tenant=acme, site=nyc and vlan=20.
+type ClientMap struct {
+	file    string
+	entries atomic.Value // *clientMapEntries, swapped by Reload
+}
+
+type clientMapEntries struct {
+	addresses  prefixTree
+	labelNames []string
+}
+
+func NewClientMap(file string) (*ClientMap, error) {
+	entries, err := loadClientMapEntries(file)
+	if err != nil {
+		return nil, err
+	}
+	m := &ClientMap{file: file}
+	m.entries.Store(entries)
+	return m, nil
+}
+
+// Reads the map file again and swaps it in at once. The labels of the
+// metrics cannot change while running, so a file with other label names is
+// refused and the map in use is kept.
+func (m *ClientMap) Reload() error {
+	entries, err := loadClientMapEntries(m.file)
+	if err != nil {
+		return err
+	}
+	current := m.LabelNames()
+	if !reflect.DeepEqual(entries.labelNames, current) {
+		return fmt.Errorf("%s: the client map labels changed from %v to %v, which needs a restart", m.file, current, entries.labelNames)
+	}
+	m.entries.Store(entries)
+	return nil
+}
+
+// The map file, for watching it for changes
+func (m *ClientMap) Files() []string {
+	return []string{m.file}
+}
+
+// The names of the labels set by any entry, sorted
+func (m *ClientMap) LabelNames() []string {
+	return m.entries.Load().(*clientMapEntries).labelNames
+}
+
+// The label values of a client address, in the order of LabelNames. Labels
+// no entry sets for the address, and all of them for anything that is not
+// an address, are ClientMapUnknown.
+func (m *ClientMap) Lookup(address string) []string {
+	entries := m.entries.Load().(*clientMapEntries)
+	values := make([]string, len(entries.labelNames))
+	for i := range values {
+		values[i] = ClientMapUnknown
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return values
+	}
+	entries.addresses.walk(ip, func(node *prefixNode) {
+		for i, name := range entries.labelNames {
+			if value, ok := node.labels[name]; ok {
+				values[i] = value
+			}
+		}
+	})
+	return values
+}
+
+func loadClientMapEntries(fileName string) (*clientMapEntries, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := &clientMapEntries{}
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if err := entries.add(fields[0], fields[1:]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", fileName, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(entries.labelNames)
+	return entries, nil
+}
+
+func (e *clientMapEntries) add(network string, pairs []string) error {
+	if len(pairs) == 0 {
+		return fmt.Errorf("`%s` has no labels", network)
+	}
+	labels := make(map[string]string)
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("`%s` is not of the form LABEL=VALUE", pair)
+		}
+		if !model.LabelName(parts[0]).IsValid() {
+			return fmt.Errorf("`%s` is not a valid label name", parts[0])
+		}
+		labels[parts[0]] = parts[1]
+	}
+
+	var node *prefixNode
+	if strings.Contains(network, "/") {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return err
+		}
+		ones, _ := ipNet.Mask.Size()
+		node = e.addresses.insert(ipNet.IP, ones)
+	} else if ip := net.ParseIP(network); ip != nil {
+		node = e.addresses.insert(ip, -1)
+	} else {
+		return fmt.Errorf("`%s` is neither an address nor a CIDR range", network)
+	}
+
+	/* A network listed twice takes the labels of both lines, the later ones winning */
+	if node.labels == nil {
+		node.labels = make(map[string]string)
+	}
+	for name, value := range labels {
+		node.labels[name] = value
+		if !containsString(e.labelNames, name) {
+			e.labelNames = append(e.labelNames, name)
+		}
+	}
+	return nil
+}
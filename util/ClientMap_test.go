@@ -0,0 +1,79 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestClientMap(t *testing.T) {
+	clientMap, err := NewClientMap("testdata/clients.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels := clientMap.LabelNames(); !reflect.DeepEqual(labels, []string{"site", "tenant", "vlan"}) {
+		t.Fatalf("Expected the labels site, tenant and vlan but got %v", labels)
+	}
+
+	for address, expected := range map[string][]string{
+		"10.1.9.9":         {"nyc", "acme", "20"},
+		"10.1.2.3":         {"nyc", "acme", "30"},
+		"10.2.0.1":         {"unknown", "acme", "unknown"},
+		"2001:db8:1::53":   {"ams", "acme", "unknown"},
+		"2001:db8:2::53":   {"unknown", "unknown", "unknown"},
+		"192.168.0.123":    {"unknown", "unknown", "unknown"},
+		"printer.corp.lan": {"unknown", "unknown", "unknown"},
+	} {
+		if values := clientMap.Lookup(address); !reflect.DeepEqual(values, expected) {
+			t.Errorf("Expected %v for %s but got %v", expected, address, values)
+		}
+	}
+}
+
+func TestClientMapReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clientmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "clients.map")
+
+	if err := ioutil.WriteFile(file, []byte("10.1.0.0/16 site=nyc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clientMap, err := NewClientMap(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("10.1.0.0/16 site=bos\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientMap.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if site := clientMap.Lookup("10.1.0.1")[0]; site != "bos" {
+		t.Fatalf("Expected the reloaded site bos but got %s", site)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("10.1.0.0/16 office=bos\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientMap.Reload(); err == nil {
+		t.Fatalf("Expected a reload with other label names to be refused")
+	}
+	if site := clientMap.Lookup("10.1.0.1")[0]; site != "bos" {
+		t.Fatalf("Expected the map in use to be kept but got %s", site)
+	}
+
+	for _, content := range []string{"10.1.0.0/16\n", "10.1.0.0/16 site\n", "10.1.0.0/33 site=nyc\n", "nyc.corp site=nyc\n", "10.1.0.0/16 my-site=nyc\n"} {
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewClientMap(file); err == nil {
+			t.Errorf("Expected an error for %q", content)
+		}
+	}
+}
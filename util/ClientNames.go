@@ -0,0 +1,339 @@
+package util
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The formats of files ClientNames can read
+var ClientNamesFormats = []string{"dhcpd", "kea", "dnsmasq", "hosts"}
+
+// ClientNames is the ClientIdentity of a file that knows the hostnames of
+// clients without asking DNS:
+//
+//	dhcpd    the dhcpd.leases file of ISC dhcpd
+//	kea      a CSV lease file of the Kea memfile backend, for IPv4 or IPv6
+//	dnsmasq  the dnsmasq.leases file of dnsmasq
+//	hosts    a file of 'ADDRESS NAME [ALIAS...]' lines like /etc/hosts
+//
+// Only leases that are active and not past their end name a client, also
+// when they end between two reloads.
+type ClientNames struct {
+	format string
+	file   string
+	names  atomic.Value // map[string]clientName by address, swapped by Reload
+	now    func() time.Time
+}
+
+// The name of a client until the end of its lease, which is zero for names
+// that do not end
+type clientName struct {
+	name string
+	ends time.Time
+}
+
+// Takes the file as FORMAT:PATH, such as dnsmasq:/var/lib/misc/dnsmasq.leases
+func NewClientNames(spec string) (*ClientNames, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || !containsString(ClientNamesFormats, parts[0]) {
+		return nil, fmt.Errorf("`%s` is not of the form FORMAT:PATH with a format of %s", spec, strings.Join(ClientNamesFormats, ", "))
+	}
+	c := &ClientNames{format: parts[0], file: parts[1], now: time.Now}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reads the file again and swaps the names in at once
+func (c *ClientNames) Reload() error {
+	file, err := os.Open(c.file)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var names map[string]clientName
+	switch c.format {
+	case "dhcpd":
+		names, err = parseDhcpdLeases(file, c.now())
+	case "kea":
+		names, err = parseKeaLeases(file, c.now())
+	case "dnsmasq":
+		names, err = parseDnsmasqLeases(file, c.now())
+	case "hosts":
+		names, err = parseHostsFile(file)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", c.file, err)
+	}
+	c.names.Store(names)
+	return nil
+}
+
+// The file, for watching it for changes
+func (c *ClientNames) Files() []string {
+	return []string{c.file}
+}
+
+// The number of addresses with a name
+func (c *ClientNames) Len() int {
+	return len(c.names.Load().(map[string]clientName))
+}
+
+func (c *ClientNames) Lookup(address string) string {
+	names := c.names.Load().(map[string]clientName)
+	name, ok := names[address]
+	/* Addresses in the log are usually in their canonical form already */
+	if ip := net.ParseIP(address); !ok && ip != nil && ip.String() != address {
+		name, ok = names[ip.String()]
+	}
+	if !ok || (!name.ends.IsZero() && !name.ends.After(c.now())) {
+		return ""
+	}
+	return name.name
+}
+
+// Adds a name for an address until ends, or removes it when the name is
+// empty
+func setClientName(names map[string]clientName, address string, name string, ends time.Time) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return
+	}
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		delete(names, ip.String())
+		return
+	}
+	names[ip.String()] = clientName{name: name, ends: ends}
+}
+
+// Reads the lease blocks of a dhcpd.leases file:
+//
+//	lease 192.168.0.10 {
+//	  ends 4 2021/06/03 22:00:00;
+//	  binding state active;
+//	  client-hostname "laptop";
+//	}
+//
+// dhcpd appends a new block for every change, so the last block of an
+// address wins
+func parseDhcpdLeases(r io.Reader, now time.Time) (map[string]clientName, error) {
+	names := map[string]clientName{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	depth := 0
+	address := ""
+	name := ""
+	active := true
+	var ends time.Time
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		/* A statement may be followed by a comment */
+		statement := text
+		if i := strings.IndexByte(text, ';'); i >= 0 && !strings.HasPrefix(text, "client-hostname") {
+			statement = text[:i]
+		}
+		fields := strings.Fields(statement)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case depth == 0 && fields[0] == "lease" && strings.HasSuffix(text, "{"):
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("%d: `%s` is not a lease", line, text)
+			}
+			address, name, active, ends = fields[1], "", true, time.Time{}
+		case depth == 1 && address != "" && fields[0] == "client-hostname":
+			name = parseDhcpdString(strings.TrimSpace(strings.TrimPrefix(text, "client-hostname")))
+		case depth == 1 && address != "" && fields[0] == "binding" && len(fields) == 3 && fields[1] == "state":
+			active = fields[2] == "active"
+		case depth == 1 && address != "" && fields[0] == "ends":
+			ends, _ = parseDhcpdTime(fields[1:])
+		}
+
+		depth += strings.Count(text, "{") - strings.Count(text, "}")
+		if depth < 0 {
+			return nil, fmt.Errorf("%d: unbalanced `}`", line)
+		}
+		if depth == 0 && address != "" {
+			if active && (ends.IsZero() || ends.After(now)) {
+				setClientName(names, address, name, ends)
+			} else {
+				setClientName(names, address, "", ends)
+			}
+			address = ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("the last lease is not closed")
+	}
+	return names, nil
+}
+
+// Reads a quoted string such as "living room", in which dhcpd escapes quotes
+// and unprintable characters with a backslash, up to its closing quote
+func parseDhcpdString(text string) string {
+	if !strings.HasPrefix(text, `"`) {
+		return strings.TrimSuffix(text, ";")
+	}
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			if name, err := strconv.Unquote(text[:i+1]); err == nil {
+				return name
+			}
+			return text[1:i]
+		}
+	}
+	return strings.Trim(text, `";`)
+}
+
+// Reads the time of an ends statement: 'never', 'epoch SECONDS' or
+// 'WEEKDAY YYYY/MM/DD HH:MM:SS' in UTC
+func parseDhcpdTime(fields []string) (time.Time, bool) {
+	switch {
+	case len(fields) == 1 && fields[0] == "never":
+		return time.Time{}, false
+	case len(fields) == 2 && fields[0] == "epoch":
+		seconds, err := strconv.ParseInt(fields[1], 10, 64)
+		return time.Unix(seconds, 0), err == nil
+	case len(fields) == 3:
+		t, err := time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2])
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// Reads a Kea memfile lease file, which starts with a header naming its
+// columns. Kea appends a row for every change, so the last row of an address
+// wins. Leases in any state but 0, the default, are not in use.
+func parseKeaLeases(r io.Reader, now time.Time) (map[string]clientName, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return map[string]clientName{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"address", "hostname", "expire", "state"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("the header has no `%s` column", name)
+		}
+	}
+
+	names := map[string]clientName{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		address := record[columns["address"]]
+		name := strings.Replace(record[columns["hostname"]], "&#x2c", ",", -1)
+		expire, err := strconv.ParseInt(record[columns["expire"]], 10, 64)
+		ends := time.Unix(expire, 0)
+		if err != nil || record[columns["state"]] != "0" || !ends.After(now) {
+			name = ""
+		}
+		setClientName(names, address, name, ends)
+	}
+	return names, nil
+}
+
+// Reads a dnsmasq.leases file of 'EXPIRY MAC ADDRESS HOSTNAME CLIENT-ID'
+// lines for IPv4, and 'EXPIRY IAID ADDRESS HOSTNAME CLIENT-ID' lines after a
+// 'duid' line for IPv6. An expiry of 0 never expires and a hostname of '*'
+// is not known.
+func parseDnsmasqLeases(r io.Reader, now time.Time) (map[string]clientName, error) {
+	names := map[string]clientName{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] == "duid" {
+			continue
+		}
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("%d: expected at least 4 fields but found %d", line, len(fields))
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%d: `%s` is not an expiry time", line, fields[0])
+		}
+		var ends time.Time
+		if expiry != 0 {
+			ends = time.Unix(expiry, 0)
+		}
+		name := fields[3]
+		if name == "*" || (!ends.IsZero() && !ends.After(now)) {
+			name = ""
+		}
+		setClientName(names, fields[2], name, ends)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Reads 'ADDRESS NAME [ALIAS...]' lines, naming each address by its first
+// name. The first line of an address wins, as it does in /etc/hosts.
+func parseHostsFile(r io.Reader) (map[string]clientName, error) {
+	names := map[string]clientName{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%d: `%s` has no name", line, fields[0])
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			return nil, fmt.Errorf("%d: `%s` is not an address", line, fields[0])
+		}
+		if _, ok := names[ip.String()]; !ok {
+			setClientName(names, fields[0], fields[1], time.Time{})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
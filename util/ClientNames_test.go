@@ -0,0 +1,169 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testClientNames(t *testing.T, spec string, expected map[string]string) *ClientNames {
+	names, err := NewClientNames(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for address, name := range expected {
+		if found := names.Lookup(address); found != name {
+			t.Fatalf("Expected '%s' for %s in %s but got '%s'", name, address, spec, found)
+		}
+	}
+	return names
+}
+
+func TestClientNamesDhcpd(t *testing.T) {
+	testClientNames(t, "dhcpd:testdata/dhcpd.leases", map[string]string{
+		"192.168.0.10": "laptop",
+		"192.168.0.11": "", // ended
+		"192.168.0.12": "", // freed by a later block
+		"192.168.0.13": "phone",
+		"192.168.0.14": `Living Room "TV"; 2nd`,
+	})
+}
+
+func TestClientNamesKea(t *testing.T) {
+	testClientNames(t, "kea:testdata/kea-leases4.csv", map[string]string{
+		"192.168.0.20": "desktop.example.com",
+		"192.168.0.21": "", // expired
+		"192.168.0.22": "", // declined
+		"192.168.0.23": "tv,living room",
+	})
+}
+
+func TestClientNamesDnsmasq(t *testing.T) {
+	testClientNames(t, "dnsmasq:testdata/dnsmasq.leases", map[string]string{
+		"192.168.0.30":          "tablet",
+		"192.168.0.31":          "", // expired
+		"192.168.0.32":          "", // no hostname
+		"192.168.0.33":          "nas",
+		"2001:db8::30":          "tablet6",
+		"2001:db8:0:0:0:0:0:30": "tablet6",
+	})
+}
+
+func TestClientNamesHosts(t *testing.T) {
+	names := testClientNames(t, "hosts:testdata/hosts", map[string]string{
+		"127.0.0.1":    "localhost",
+		"192.168.0.40": "router.example.com",
+		"2001:db8::40": "router6",
+		"192.168.0.41": "",
+	})
+	if names.Len() != 3 {
+		t.Fatalf("Expected 3 named addresses but found %d", names.Len())
+	}
+}
+
+func TestClientNamesErrors(t *testing.T) {
+	for _, spec := range []string{"testdata/hosts", "leases:testdata/hosts", "hosts:testdata/missing"} {
+		if _, err := NewClientNames(spec); err == nil {
+			t.Fatalf("Expected an error for %s", spec)
+		}
+	}
+	if _, err := NewClientNames("hosts:testdata/dnsmasq.leases"); err == nil {
+		t.Fatalf("Expected an error for a lease file read as hosts")
+	}
+	if _, err := parseDhcpdLeases(strings.NewReader("lease 192.168.0.10 {\n  client-hostname \"laptop\";\n"), time.Now()); err == nil {
+		t.Fatalf("Expected an error for a lease that is not closed")
+	}
+	if _, err := parseKeaLeases(strings.NewReader("address,hwaddr\n192.168.0.20,00:11:22:33:44:60\n"), time.Now()); err == nil {
+		t.Fatalf("Expected an error for a Kea file without hostnames")
+	}
+}
+
+func TestClientNamesReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clientnames")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "dnsmasq.leases")
+	if err := ioutil.WriteFile(file, []byte("0 00:11:22:33:44:70 192.168.0.30 tablet *\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	names := testClientNames(t, "dnsmasq:"+file, map[string]string{"192.168.0.30": "tablet"})
+
+	/* A file that fails to read keeps the names in use */
+	if err := ioutil.WriteFile(file, []byte("broken\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := names.Reload(); err == nil {
+		t.Fatalf("Expected an error reloading a broken file")
+	}
+	if name := names.Lookup("192.168.0.30"); name != "tablet" {
+		t.Fatalf("Expected the names in use to be kept but got '%s'", name)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("0 00:11:22:33:44:70 192.168.0.30 laptop *\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := names.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if name := names.Lookup("192.168.0.30"); name != "laptop" {
+		t.Fatalf("Expected laptop after the reload but got '%s'", name)
+	}
+}
+
+func TestClientNamesExpiry(t *testing.T) {
+	names, err := NewClientNames("dnsmasq:testdata/dnsmasq.leases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	/* Leases already past their end are left out on reload */
+	names.now = func() time.Time { return time.Unix(4085913600, 0) }
+	if err := names.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if name := names.Lookup("192.168.0.30"); name != "" {
+		t.Fatalf("Expected no name for a lease at its expiry but got '%s'", name)
+	}
+	if name := names.Lookup("192.168.0.33"); name != "nas" {
+		t.Fatalf("Expected a lease that never expires to keep its name but got '%s'", name)
+	}
+
+	/* A lease that ends before the next reload loses its name at its end */
+	names.now = func() time.Time { return time.Unix(4085913599, 0) }
+	if err := names.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if name := names.Lookup("2001:db8:0:0:0:0:0:30"); name != "tablet6" {
+		t.Fatalf("Expected tablet6 before its lease ends but got '%s'", name)
+	}
+	names.now = func() time.Time { return time.Unix(4085913600, 0) }
+	if name := names.Lookup("2001:db8:0:0:0:0:0:30"); name != "" {
+		t.Fatalf("Expected no name once the lease ended but got '%s'", name)
+	}
+	if name := names.Lookup("192.168.0.33"); name != "nas" {
+		t.Fatalf("Expected a lease that never expires to keep its name but got '%s'", name)
+	}
+}
+
+func TestClientIdentities(t *testing.T) {
+	hosts := testClientNames(t, "hosts:testdata/hosts", nil)
+	leases := testClientNames(t, "dnsmasq:testdata/dnsmasq.leases", nil)
+	identities := ClientIdentities{leases, hosts}
+
+	if name := identities.Lookup("192.168.0.30"); name != "tablet" {
+		t.Fatalf("Expected tablet from the leases but got '%s'", name)
+	}
+	if name := identities.Lookup("192.168.0.40"); name != "router.example.com" {
+		t.Fatalf("Expected router.example.com from the hosts file but got '%s'", name)
+	}
+	if name := identities.Lookup("10.0.0.1"); name != "" {
+		t.Fatalf("Expected no name but got '%s'", name)
+	}
+	if identities.Own(LogMatch{QueryClient: "192.168.0.30", QueryPort: "53", QueryName: "bitnebula.com"}) {
+		t.Fatalf("Expected files never to make lookups of their own")
+	}
+}
@@ -0,0 +1,208 @@
+package util
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// A subscription is one consumer of parsed log events. Events that pass the
+// subscriber's filter are queued on its channel, or handed straight to its
+// handler when the dispatcher does not queue.
+type subscription struct {
+	name          string
+	filter        atomic.Value // *LogFilter, swapped by SetFilter
+	reverseLookup bool
+	handler       func(LogMatch)
+	events        chan LogMatch
+}
+
+// The Dispatcher parses every line exactly once and fans the result out to
+// all subscribers. Each subscriber has its own queue and goroutine, and sends
+// never block: if a queue is full, the event is dropped for that subscriber
+// only and counted. With a buffer size of 0 there are no queues at all and
+// the handlers are called directly, which is what replays rely on to never
+// drop anything. Clients are named by the identities if there are any, and
+// by a synchronous reverse lookup otherwise.
+type Dispatcher struct {
+	matcher       *LogMatcher
+	bufferSize    int
+	labelNames    []string
+	identity      ClientIdentities
+	subscriptions []*subscription
+	consumers     sync.WaitGroup
+	lock          sync.RWMutex
+	closed        bool
+	handlerLock   sync.Mutex
+	linesMetric   prometheus.Counter
+	droppedMetric *prometheus.CounterVec
+}
+
+func NewDispatcher(namespace string, matcher *LogMatcher, bufferSize int, labelNames []string, identity ClientIdentities) *Dispatcher {
+	linesMetric := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "pipeline",
+			Name:      "lines_total",
+			Help:      "Total log lines and dnstap messages read by the exporter",
+		},
+	)
+
+	droppedMetric := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "pipeline",
+			Name:      "dropped_total",
+			Help:      "Events dropped because a collector could not keep up",
+		},
+		[]string{"collector"},
+	)
+
+	return &Dispatcher{
+		matcher:       matcher,
+		bufferSize:    bufferSize,
+		labelNames:    labelNames,
+		identity:      identity,
+		linesMetric:   linesMetric,
+		droppedMetric: droppedMetric,
+	}
+}
+
+// Registers a new consumer. All subscriptions must be made before the first
+// call to Dispatch or Send. The handler is never called concurrently with
+// itself.
+func (d *Dispatcher) Subscribe(name string, filter *LogFilter, reverseLookup bool, handler func(LogMatch)) {
+	sub := &subscription{
+		name:          name,
+		reverseLookup: reverseLookup,
+		handler:       handler,
+	}
+	sub.filter.Store(filter)
+	d.subscriptions = append(d.subscriptions, sub)
+	d.droppedMetric.WithLabelValues(name).Add(0)
+
+	if d.bufferSize > 0 {
+		sub.events = make(chan LogMatch, d.bufferSize)
+		d.consumers.Add(1)
+		go func() {
+			defer d.consumers.Done()
+			for info := range sub.events {
+				sub.handler(info)
+			}
+		}()
+	}
+}
+
+// Replaces the filter of a subscription while events keep flowing. Events
+// already queued for the subscriber are not filtered again.
+func (d *Dispatcher) SetFilter(name string, filter *LogFilter) {
+	for _, sub := range d.subscriptions {
+		if sub.name == name {
+			sub.filter.Store(filter)
+		}
+	}
+}
+
+// The names of the source labels every event may carry. Collectors add these
+// to each of their vectors.
+func (d *Dispatcher) LabelNames() []string {
+	return d.labelNames
+}
+
+// Parses a raw log line and hands the result, tagged with the labels of the
+// source it came from, to every subscriber
+func (d *Dispatcher) Dispatch(line string, labels map[string]string) {
+	d.Send(d.matcher.Match(line, labels))
+}
+
+// Hands an already parsed event to every subscriber
+func (d *Dispatcher) Send(info LogMatch) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if d.closed {
+		return
+	}
+
+	d.linesMetric.Add(1)
+	if !info.Matched {
+		return
+	}
+	if d.identity.Own(info) {
+		log.Debugf("Ignoring the reverse lookup of %s made by the exporter", info.QueryName)
+		return
+	}
+
+	/* Only look the client up once, and only if someone wants it */
+	resolved := ""
+	for _, sub := range d.subscriptions {
+		filter := sub.filter.Load().(*LogFilter)
+		if !filter.MatchNameInView(info.QueryName, info.QueryView) {
+			continue
+		}
+
+		event := info
+		if sub.reverseLookup {
+			if resolved == "" {
+				resolved = d.reverseLookup(info.QueryClient)
+			}
+			event.ClientAddress = info.QueryClient
+			event.QueryClient = resolved
+		}
+
+		if !filter.MatchClient(info.QueryClient, event.QueryClient) {
+			continue
+		}
+
+		if sub.events == nil {
+			d.handlerLock.Lock()
+			sub.handler(event)
+			d.handlerLock.Unlock()
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+		default:
+			log.Debugf("Dropping event for %s: buffer full", sub.name)
+			d.droppedMetric.WithLabelValues(sub.name).Add(1)
+		}
+	}
+}
+
+func (d *Dispatcher) reverseLookup(client string) string {
+	if d.identity == nil {
+		return ReverseLookup(client)
+	}
+	if name := d.identity.Lookup(client); name != "" {
+		return name
+	}
+	return client
+}
+
+// Closes every subscriber channel and waits until the consumers have handled
+// every event still queued. Anything dispatched afterwards is ignored.
+func (d *Dispatcher) Close() {
+	d.lock.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, sub := range d.subscriptions {
+			if sub.events != nil {
+				close(sub.events)
+			}
+		}
+	}
+	d.lock.Unlock()
+	d.consumers.Wait()
+}
+
+func (d *Dispatcher) Collect(ch chan<- prometheus.Metric) {
+	d.linesMetric.Collect(ch)
+	d.droppedMetric.Collect(ch)
+}
+
+func (d *Dispatcher) Describe(ch chan<- *prometheus.Desc) {
+	d.linesMetric.Describe(ch)
+	d.droppedMetric.Describe(ch)
+}
@@ -0,0 +1,133 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const dispatcherLine = "05-Jun-2021 07:24:47.780 queries: info: client @0xadfc0030 192.168.0.123#59542 (bitnebula.com): query: bitnebula.com IN A + (192.168.0.456)"
+
+func TestDispatcherFanOut(t *testing.T) {
+	matcher := NewLogMatcher()
+	dispatcher := NewDispatcher("test", &matcher, 1, nil, nil)
+
+	all := make(chan LogMatch, 1)
+	filtered := make(chan LogMatch, 1)
+	dispatcher.Subscribe("all", nil, false, func(info LogMatch) { all <- info })
+	dispatcher.Subscribe("filtered", &LogFilter{Exclude: nameList(t, "bitnebula.com")}, false, func(info LogMatch) { filtered <- info })
+
+	dispatcher.Dispatch(dispatcherLine, nil)
+	dispatcher.Close()
+
+	select {
+	case info := <-all:
+		if info.QueryName != "bitnebula.com" {
+			t.Fatalf(`Expected target name of bitnebula.com but got '%s'`, info.QueryName)
+		}
+	default:
+		t.Fatalf("Unfiltered subscriber did not receive the event")
+	}
+
+	select {
+	case <-filtered:
+		t.Fatalf("Filtered subscriber received an excluded name")
+	default:
+	}
+}
+
+func TestDispatcherDoesNotBlock(t *testing.T) {
+	matcher := NewLogMatcher()
+	dispatcher := NewDispatcher("test", &matcher, 1, nil, nil)
+
+	release := make(chan bool)
+	dispatcher.Subscribe("slow", nil, false, func(info LogMatch) { <-release })
+
+	/* The handler is stuck on the first line and the second fills the queue.
+	   Anything after that must be dropped rather than block. */
+	for i := 0; i < 5; i++ {
+		dispatcher.Dispatch(dispatcherLine, nil)
+	}
+
+	if dropped := testutil.ToFloat64(dispatcher.droppedMetric.WithLabelValues("slow")); dropped < 3 {
+		t.Fatalf("Expected at least 3 dropped events but found %f", dropped)
+	}
+	close(release)
+	dispatcher.Close()
+}
+
+func TestDispatcherUnbuffered(t *testing.T) {
+	matcher := NewLogMatcher()
+	dispatcher := NewDispatcher("test", &matcher, 0, nil, nil)
+
+	count := 0
+	dispatcher.Subscribe("direct", nil, false, func(info LogMatch) { count++ })
+
+	for i := 0; i < 5; i++ {
+		dispatcher.Dispatch(dispatcherLine, nil)
+	}
+	if count != 5 {
+		t.Fatalf("Expected 5 events handled directly but found %d", count)
+	}
+}
+
+func TestDispatcherSetFilter(t *testing.T) {
+	matcher := NewLogMatcher()
+	dispatcher := NewDispatcher("test", &matcher, 0, nil, nil)
+
+	count := 0
+	dispatcher.Subscribe("names", nil, false, func(info LogMatch) { count++ })
+
+	dispatcher.Dispatch(dispatcherLine, nil)
+	dispatcher.SetFilter("names", &LogFilter{Exclude: nameList(t, "bitnebula.com")})
+	dispatcher.Dispatch(dispatcherLine, nil)
+	if count != 1 {
+		t.Fatalf("Expected 1 event before the filter was swapped but found %d", count)
+	}
+}
+
+func TestDispatcherResolver(t *testing.T) {
+	resolver, _, _ := testResolver(10, time.Hour, time.Hour, map[string]string{"192.168.0.123": "host.example.com"})
+	defer resolver.Close()
+	matcher := NewLogMatcher()
+	dispatcher := NewDispatcher("test", &matcher, 0, nil, ClientIdentities{resolver})
+
+	var clients []string
+	dispatcher.Subscribe("names", nil, true, func(info LogMatch) { clients = append(clients, info.QueryClient) })
+	dispatcher.Dispatch(dispatcherLine, nil)
+	settle(t, resolver)
+	dispatcher.Dispatch(dispatcherLine, nil)
+
+	if len(clients) != 2 || clients[0] != "192.168.0.123" || clients[1] != "host.example.com" {
+		t.Fatalf("Expected the client address and then its name but got %v", clients)
+	}
+}
+
+func TestDispatcherIgnoresOwnLookups(t *testing.T) {
+	stub := startStubDNSServer(t, "udp", nil)
+	defer stub.server.Shutdown()
+	client, err := NewPTRClient(stub.address, "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := NewReverseResolver("test", client, 10, time.Hour, time.Hour, 0, 0)
+	defer resolver.Close()
+	matcher := NewLogMatcher()
+	dispatcher := NewDispatcher("test", &matcher, 0, nil, ClientIdentities{resolver})
+
+	var clients []string
+	dispatcher.Subscribe("stats", nil, true, func(info LogMatch) { clients = append(clients, info.QueryClient) })
+	dispatcher.Dispatch(dispatcherLine, nil)
+
+	/* The server logs the lookup the dispatcher just made */
+	for _, query := range stub.Queries() {
+		dispatcher.Send(query)
+	}
+	if len(clients) != 1 || clients[0] != "host.example.com" {
+		t.Fatalf("Expected only the logged query, from host.example.com, but got %v", clients)
+	}
+	if own := testutil.ToFloat64(resolver.ownMetric); own != 1 {
+		t.Fatalf("Expected 1 own query but found %f", own)
+	}
+}
@@ -0,0 +1,205 @@
+package util
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// A GroupMap assigns names to logical groups, such as service=payments or
+// category=telemetry, by lists of name patterns. The patterns are the entries
+// a NameList understands.
+//
+// It is read from YAML
+//
+//	groups:
+//	  - labels: {service: payments}
+//	    names: [.pay.example.com, api.stripe.com]
+//	  - labels: {category: telemetry}
+//	    names: ["*.telemetry.example.net"]
+//
+// or from CSV with a pattern and its labels on each line
+//
+//	.pay.example.com,service=payments
+//	*.telemetry.example.net,category=telemetry
+type GroupMap struct {
+	groups     []nameGroup
+	labelNames []string
+}
+
+type nameGroup struct {
+	labels map[string]string
+	names  *NameList
+}
+
+type groupMapFile struct {
+	Groups []struct {
+		Labels map[string]string `yaml:"labels"`
+		Names  []string          `yaml:"names"`
+	} `yaml:"groups"`
+}
+
+// Reads a mapping, as YAML if the file name ends in .yml or .yaml and as CSV
+// otherwise
+func LoadGroupMap(fileName string) (*GroupMap, error) {
+	var m *GroupMap
+	var err error
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yml", ".yaml":
+		m, err = loadGroupMapYAML(fileName)
+	default:
+		m, err = loadGroupMapCSV(fileName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fileName, err)
+	}
+	return m, nil
+}
+
+func loadGroupMapYAML(fileName string) (*GroupMap, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	file := groupMapFile{}
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, err
+	}
+
+	m := &GroupMap{}
+	for i, group := range file.Groups {
+		if len(group.Labels) == 0 {
+			return nil, fmt.Errorf("group %d has no labels", i+1)
+		}
+		names := NewNameList()
+		for _, entry := range group.Names {
+			if err := names.Add(entry); err != nil {
+				return nil, fmt.Errorf("group %d: %s", i+1, err)
+			}
+		}
+		if err := m.add(group.Labels, names); err != nil {
+			return nil, fmt.Errorf("group %d: %s", i+1, err)
+		}
+	}
+	return m, nil
+}
+
+func loadGroupMapCSV(fileName string) (*GroupMap, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	/* Lines with the same labels make up one group */
+	m := &GroupMap{}
+	byLabels := make(map[string]*NameList)
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		/* Each line on its own, so errors carry the right line number */
+		reader := csv.NewReader(strings.NewReader(text))
+		reader.TrimLeadingSpace = true
+		record, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: expected a pattern followed by labels such as service=payments", line)
+		}
+
+		labels := make(map[string]string)
+		for _, field := range record[1:] {
+			parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("line %d: `%s` is not of the form LABEL=VALUE", line, field)
+			}
+			labels[parts[0]] = parts[1]
+		}
+
+		key := groupKey(labels)
+		names, ok := byLabels[key]
+		if !ok {
+			names = NewNameList()
+			if err := m.add(labels, names); err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err)
+			}
+			byLabels[key] = names
+		}
+		if err := names.Add(strings.TrimSpace(record[0])); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *GroupMap) add(labels map[string]string, names *NameList) error {
+	for name := range labels {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("`%s` is not a valid label name", name)
+		}
+		if !containsString(m.labelNames, name) {
+			m.labelNames = append(m.labelNames, name)
+		}
+	}
+	sort.Strings(m.labelNames)
+	m.groups = append(m.groups, nameGroup{labels: labels, names: names})
+	return nil
+}
+
+// The names of the labels of every group, sorted
+func (m *GroupMap) LabelNames() []string {
+	return m.labelNames
+}
+
+// The label values of a name, in the order of LabelNames. When several
+// groups match, each label takes its value from the first group in the file
+// that sets it. Labels no matching group sets are empty.
+func (m *GroupMap) Match(name string) []string {
+	values := make([]string, len(m.labelNames))
+	for _, group := range m.groups {
+		if !group.names.Match(name) {
+			continue
+		}
+		for i, labelName := range m.labelNames {
+			if value, ok := group.labels[labelName]; ok && values[i] == "" {
+				values[i] = value
+			}
+		}
+	}
+	return values
+}
+
+func groupKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
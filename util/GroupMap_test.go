@@ -0,0 +1,62 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGroupMap(t *testing.T) {
+	for _, file := range []string{"testdata/groups.yml", "testdata/groups.csv"} {
+		groups, err := LoadGroupMap(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if labels := groups.LabelNames(); !reflect.DeepEqual(labels, []string{"category", "service"}) {
+			t.Fatalf("Expected the labels category and service from %s but got %v", file, labels)
+		}
+
+		for name, expected := range map[string][]string{
+			"checkout.pay.example.com": {"", "payments"},
+			"API.stripe.com.":          {"", "payments"},
+			"eu.telemetry.example.net": {"telemetry", "web"},
+			"metrics12.example.org":    {"telemetry", ""},
+			"www.example.net":          {"public", "web"},
+			"bitnebula.com":            {"", ""},
+			"telemetry.example.net":    {"public", "web"},
+			"stripe.com":               {"", ""},
+		} {
+			if values := groups.Match(name); !reflect.DeepEqual(values, expected) {
+				t.Errorf("Expected %v for %s in %s but got %v", expected, name, file, values)
+			}
+		}
+	}
+}
+
+func TestGroupMapErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for content, expected := range map[string]string{
+		"groups.csv:.example.com":                                   "line 1: expected a pattern",
+		"groups.csv:# comment\n.example.com,service":                "line 2: `service` is not of the form",
+		"groups.csv:.example.com,my-service=web":                    "`my-service` is not a valid label name",
+		"groups.yml:groups:\n  - names: [.example.com]":             "group 1 has no labels",
+		"groups.yml:groups:\n  - labels: {a: b}\n    nmes: [x.com]": "field nmes not found",
+	} {
+		parts := strings.SplitN(content, ":", 2)
+		file := filepath.Join(dir, parts[0])
+		if err := ioutil.WriteFile(file, []byte(parts[1]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadGroupMap(file); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error with `%s` for %q but got %v", expected, parts[1], err)
+		}
+	}
+}
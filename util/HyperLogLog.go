@@ -0,0 +1,147 @@
+package util
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// A HyperLogLog estimates the number of distinct values added to it in a
+// fixed 2^precision bytes, with a standard error of 1.04/sqrt(2^precision)
+// (Flajolet et al., 2007, with the small range correction of Heule et al.,
+// 2013)
+type HyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+func NewHyperLogLog(precision uint8) *HyperLogLog {
+	return &HyperLogLog{
+		precision: precision,
+		registers: make([]uint8, 1<<precision),
+	}
+}
+
+func (h *HyperLogLog) Add(value string) {
+	hash := hashString(value)
+	index := hash >> (64 - h.precision)
+	rank := uint8(bits.LeadingZeros64(hash<<h.precision|1<<(h.precision-1))) + 1
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+// Adds every value of another sketch of the same precision
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	for i, rank := range other.registers {
+		if rank > h.registers[i] {
+			h.registers[i] = rank
+		}
+	}
+}
+
+func (h *HyperLogLog) Reset() {
+	for i := range h.registers {
+		h.registers[i] = 0
+	}
+}
+
+// The estimated number of distinct values
+func (h *HyperLogLog) Count() float64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, rank := range h.registers {
+		sum += 1 / float64(uint64(1)<<rank)
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	/* Linear counting is more accurate while many registers are empty */
+	if estimate <= 2.5*m && zeros > 0 {
+		return math.Round(m * math.Log(m/float64(zeros)))
+	}
+	return math.Round(estimate)
+}
+
+// FNV-1a spreads short strings poorly over the high bits, which the
+// registers are picked by, so it is mixed with the finalizer of MurmurHash3
+func hashString(value string) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(value))
+	hash := hasher.Sum64()
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+	return hash
+}
+
+// A WindowedHyperLogLog estimates the distinct values added within a
+// sliding window. The window is split into slots of a sketch each, and the
+// oldest slot is cleared as time moves on, so the window slides by one slot
+// at a time.
+type WindowedHyperLogLog struct {
+	lock     sync.Mutex
+	slotSize time.Duration
+	slots    []*HyperLogLog
+	epochs   []int64 // the slot number each sketch holds
+}
+
+func NewWindowedHyperLogLog(window time.Duration, slots int, precision uint8) *WindowedHyperLogLog {
+	w := &WindowedHyperLogLog{
+		slotSize: window / time.Duration(slots),
+		slots:    make([]*HyperLogLog, slots),
+		epochs:   make([]int64, slots),
+	}
+	for i := range w.slots {
+		w.slots[i] = NewHyperLogLog(precision)
+		w.epochs[i] = -1
+	}
+	return w
+}
+
+func (w *WindowedHyperLogLog) Add(value string, at time.Time) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	epoch := at.UnixNano() / int64(w.slotSize)
+	i := int(epoch % int64(len(w.slots)))
+	if w.epochs[i] != epoch {
+		/* Values from before the window are not worth keeping */
+		if w.epochs[i] > epoch {
+			return
+		}
+		w.slots[i].Reset()
+		w.epochs[i] = epoch
+	}
+	w.slots[i].Add(value)
+}
+
+// The estimated distinct values added within the window ending at now
+func (w *WindowedHyperLogLog) Count(now time.Time) float64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	current := now.UnixNano() / int64(w.slotSize)
+	var merged *HyperLogLog
+	for i, slot := range w.slots {
+		if w.epochs[i] < 0 || w.epochs[i] <= current-int64(len(w.slots)) || w.epochs[i] > current {
+			continue
+		}
+		if merged == nil {
+			merged = NewHyperLogLog(slot.precision)
+		}
+		merged.Merge(slot)
+	}
+	if merged == nil {
+		return 0
+	}
+	return merged.Count()
+}
@@ -0,0 +1,65 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	for _, distinct := range []int{0, 1, 100, 5000, 200000} {
+		sketch := NewHyperLogLog(12)
+		for i := 0; i < distinct; i++ {
+			/* Each value twice, which must not count */
+			sketch.Add(fmt.Sprintf("192.168.%d.%d", i/256, i%256))
+			sketch.Add(fmt.Sprintf("192.168.%d.%d", i/256, i%256))
+		}
+
+		/* Five standard errors of 1.6% */
+		estimate := sketch.Count()
+		if math.Abs(estimate-float64(distinct)) > 0.08*float64(distinct)+1 {
+			t.Errorf("Expected about %d distinct values but estimated %f", distinct, estimate)
+		}
+	}
+}
+
+func TestWindowedHyperLogLog(t *testing.T) {
+	window := NewWindowedHyperLogLog(time.Hour, 6, 12)
+	start := time.Date(2021, 6, 5, 7, 0, 0, 0, time.UTC)
+
+	/* 100 clients at the start, 50 others half an hour later */
+	for i := 0; i < 100; i++ {
+		window.Add(fmt.Sprintf("10.0.0.%d", i), start)
+	}
+	for i := 0; i < 50; i++ {
+		window.Add(fmt.Sprintf("10.0.1.%d", i), start.Add(30*time.Minute))
+	}
+
+	for _, test := range []struct {
+		at       time.Duration
+		expected float64
+	}{
+		{10 * time.Minute, 100},
+		{40 * time.Minute, 150},
+		{70 * time.Minute, 50},
+		{2 * time.Hour, 0},
+	} {
+		count := window.Count(start.Add(test.at))
+		if math.Abs(count-test.expected) > 0.05*test.expected {
+			t.Errorf("Expected about %f distinct values %s after the start but estimated %f", test.expected, test.at, count)
+		}
+	}
+}
+
+func BenchmarkHyperLogLog(b *testing.B) {
+	sketch := NewHyperLogLog(12)
+	values := make([]string, 1000)
+	for i := range values {
+		values[i] = fmt.Sprintf("192.168.%d.%d", i/256, i%256)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sketch.Add(values[i%len(values)])
+	}
+}
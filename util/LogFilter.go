@@ -0,0 +1,74 @@
+package util
+
+import (
+	"github.com/prometheus/common/log"
+)
+
+// A LogFilter holds the include/exclude lists a single collector applies to
+// the events it receives. A nil filter lets everything through.
+type LogFilter struct {
+	Include       *NameList
+	Exclude       *NameList
+	IncludeClient *ClientList
+	ExcludeClient *ClientList
+
+	/* Name lists of a view replace the lists above for queries in that view */
+	ViewInclude map[string]*NameList
+	ViewExclude map[string]*NameList
+}
+
+func (f *LogFilter) MatchName(name string) bool {
+	return f.MatchNameInView(name, "")
+}
+
+func (f *LogFilter) MatchNameInView(name string, view string) bool {
+	if f == nil {
+		return true
+	}
+
+	include := f.Include
+	if list, ok := f.ViewInclude[view]; ok && view != "" {
+		include = list
+	}
+	exclude := f.Exclude
+	if list, ok := f.ViewExclude[view]; ok && view != "" {
+		exclude = list
+	}
+
+	if include.Len() > 0 && !include.Match(name) {
+		log.Debugf("Name %s is not in include", name)
+		return false
+	}
+	if exclude.Len() > 0 && exclude.Match(name) {
+		log.Debugf("Ignoring name %s", name)
+		return false
+	}
+	return true
+}
+
+// Takes every form of the same client, such as its address and its reverse
+// name, so both addresses and names can be listed
+func (f *LogFilter) MatchClient(clients ...string) bool {
+	if f == nil {
+		return true
+	}
+
+	if f.IncludeClient.Len() > 0 && !matchAnyClient(f.IncludeClient, clients) {
+		log.Debugf("Ignoring client for not being in include list: %v", clients)
+		return false
+	}
+	if f.ExcludeClient.Len() > 0 && matchAnyClient(f.ExcludeClient, clients) {
+		log.Debugf("Ignoring client in exclude list: %v", clients)
+		return false
+	}
+	return true
+}
+
+func matchAnyClient(list *ClientList, clients []string) bool {
+	for _, client := range clients {
+		if list.Match(client) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestLogFilterViews(t *testing.T) {
+	filter := &LogFilter{
+		Exclude:     nameList(t, "ads.example.com"),
+		ViewInclude: map[string]*NameList{"external": nameList(t, "www.example.com")},
+		ViewExclude: map[string]*NameList{"internal": nameList(t, "printer.example.com")},
+	}
+
+	for _, c := range []struct {
+		name, view string
+		expected   bool
+	}{
+		{"ads.example.com", "", false},
+		{"printer.example.com", "", true},
+		{"www.example.com", "external", true},
+		{"mail.example.com", "external", false},
+		{"ads.example.com", "external", false},
+		{"printer.example.com", "internal", false},
+		{"ads.example.com", "internal", true},
+		{"ads.example.com", "guest", false},
+	} {
+		if matched := filter.MatchNameInView(c.name, c.view); matched != c.expected {
+			t.Fatalf("Expected %s in view '%s' to match %t but got %t", c.name, c.view, c.expected, matched)
+		}
+	}
+}
@@ -4,74 +4,217 @@ import (
 	"net"
 	"regexp"
 	"strings"
-
-	"github.com/prometheus/common/log"
+	"time"
 )
 
 var LogMatcherDefaultPattern string = `client(?: @0x[0-9a-f]+)? ([^\s#]+).*query: ([^\s]+).*IN ([^\s]+)`
 
 type LogMatcher struct {
 	//05-Jun-2021 07:24:47.780 queries: info: client @0xadfc0030 192.168.0.123#59542 (bitnebula.com): query: bitnebula.com IN A + (192.168.0.456)
-	Regex         *regexp.Regexp
-	ReverseLookup bool
-	Include       map[string]bool
-	Exclude       map[string]bool
-	IncludeClient map[string]bool
-	ExcludeClient map[string]bool
+	/* Lines are read by ParseQueryLine when there is no pattern */
+	Regex *regexp.Regexp
+
+	/* Only replays care when a query happened, so only they pay for parsing it */
+	ParseTimestamp bool
+
+	/* Captured fields to add to the labels of every event */
+	Labels []string
 }
 
+// Names of the capture groups with a meaning of their own. Any other named
+// group is only kept in LogMatch.Fields.
+var LogMatcherFields = []string{"client", "name", "type", "class", "flags", "server", "view", "timestamp", "port"}
+
 type LogMatch struct {
 	Matched     bool
 	QueryClient string
 	QueryName   string
 	QueryType   string
+	Labels      map[string]string
+	Timestamp   time.Time
+
+	/* Only known from ParseQueryLine or when the pattern captures them by name */
+	QueryClass  string
+	QueryFlags  string
+	QueryServer string
+	QueryView   string
+	QueryPort   string
+	QueryECS    string
+	Fields      map[string]string
+
+	/* Only known when the event comes from dnstap */
+	QueryProtocol   string
+	ResponseCode    string
+	ResponseLatency time.Duration
+
+	/* The logged address, set when QueryClient was replaced by its reverse name */
+	ClientAddress string
 }
 
 func NewLogMatcher() LogMatcher {
 	return LogMatcher{
-		ReverseLookup: false,
-		Regex:         regexp.MustCompile(LogMatcherDefaultPattern),
+		Regex: regexp.MustCompile(LogMatcherDefaultPattern),
 	}
 }
 
 func (m LogMatcher) ExtractInfo(line string) LogMatch {
+	if m.Regex == nil {
+		result := ParseQueryLine(line)
+		if result.Matched && m.ParseTimestamp {
+			result.Timestamp, _ = ParseBindTimestamp(line)
+		}
+		return result
+	}
+
 	result := LogMatch{Matched: false}
 
 	match := m.Regex.FindStringSubmatch(line)
-	if len(match) > 0 {
-		result.Matched = true
-		result.QueryClient = match[1]
-		result.QueryName = match[2]
-		result.QueryType = match[3]
-
-		/* Check if we should avoid a DNS lookup since this name is not
-		   in the list of names we care about */
-		if len(m.Include) > 0 && !m.Include[result.QueryName] {
-			log.Debugf("Name %s is not in include", result.QueryName)
-			result.Matched = false
-		}
-		if len(m.Exclude) > 0 && m.Exclude[result.QueryName] {
-			log.Debugf("Ignoring name %s", result.QueryName)
-			result.Matched = false
-		}
+	if len(match) == 0 {
+		return result
+	}
+	result.Matched = true
 
-		if result.Matched && m.ReverseLookup {
-			if names, dnsErr := net.LookupAddr(result.QueryClient); dnsErr == nil && len(names) > 0 {
-				result.QueryClient = strings.TrimSuffix(names[0], ".")
+	/* Unnamed groups are client, name and type in that order, as before
+	   named groups were understood. A named group takes precedence. */
+	var positional [3]string
+	unnamed := 0
+	timestamp := line
+	for i, name := range m.Regex.SubexpNames() {
+		if i == 0 {
+			continue
+		}
+		if name == "" {
+			if unnamed < len(positional) {
+				positional[unnamed] = match[i]
 			}
+			unnamed++
+			continue
 		}
 
-		if len(m.IncludeClient) > 0 && !m.IncludeClient[result.QueryClient] {
-			log.Debugf("Ignoring client for not being in include list: %s", result.QueryClient)
-			result.Matched = false
+		if result.Fields == nil {
+			result.Fields = make(map[string]string)
 		}
-		if len(m.ExcludeClient) > 0 && m.ExcludeClient[result.QueryClient] {
-			log.Debugf("Ignoring client in exclude list: %s", result.QueryClient)
-			result.Matched = false
+		result.Fields[name] = match[i]
+		switch name {
+		case "class":
+			result.QueryClass = match[i]
+		case "flags":
+			result.QueryFlags = match[i]
+		case "server":
+			result.QueryServer = match[i]
+		case "view":
+			result.QueryView = match[i]
+		case "port":
+			result.QueryPort = match[i]
+		case "timestamp":
+			timestamp = match[i]
 		}
+	}
 
-		log.Debugf("Result %t for name: %s, client: %s", result.Matched, result.QueryName, result.QueryClient)
+	result.QueryClient = namedOr(result.Fields, "client", positional[0])
+	result.QueryName = namedOr(result.Fields, "name", positional[1])
+	result.QueryType = namedOr(result.Fields, "type", positional[2])
+	if m.ParseTimestamp {
+		result.Timestamp, _ = ParseBindTimestamp(timestamp)
 	}
 	return result
+}
 
+func namedOr(fields map[string]string, name string, fallback string) string {
+	if value, ok := fields[name]; ok {
+		return value
+	}
+	return fallback
+}
+
+// Extracts the info of a line like ExtractInfo and labels it with the labels
+// of the source it came from plus the fields chosen as labels
+func (m LogMatcher) Match(line string, labels map[string]string) LogMatch {
+	info := m.ExtractInfo(line)
+	info.Labels = labels
+	if len(m.Labels) == 0 || !info.Matched {
+		return info
+	}
+
+	info.Labels = make(map[string]string, len(labels)+len(m.Labels))
+	for k, v := range labels {
+		info.Labels[k] = v
+	}
+	for _, name := range m.Labels {
+		info.Labels[name] = info.Field(name)
+	}
+	return info
+}
+
+// Whether the pattern has a named capture group for the field
+func (m LogMatcher) Captures(name string) bool {
+	groups := QueryLogParserFields
+	if m.Regex != nil {
+		groups = m.Regex.SubexpNames()
+	}
+	for _, group := range groups {
+		if group == name {
+			return true
+		}
+	}
+	return false
+}
+
+// The address of the client, even when QueryClient holds its reverse name
+func (info LogMatch) Address() string {
+	if info.ClientAddress != "" {
+		return info.ClientAddress
+	}
+	return info.QueryClient
+}
+
+// The value of a field by its capture group name
+func (info LogMatch) Field(name string) string {
+	switch name {
+	case "client":
+		return info.QueryClient
+	case "name":
+		return info.QueryName
+	case "type":
+		return info.QueryType
+	case "class":
+		return info.QueryClass
+	case "flags":
+		return info.QueryFlags
+	case "server":
+		return info.QueryServer
+	case "view":
+		return info.QueryView
+	case "port":
+		return info.QueryPort
+	case "ecs":
+		return info.QueryECS
+	}
+	return info.Fields[name]
+}
+
+// Parses the time BIND printed at the start of a log line. The default
+// print-time format is in local time, the ISO 8601 ones may carry a zone.
+func ParseBindTimestamp(line string) (time.Time, bool) {
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) >= 2 {
+		if t, err := time.ParseInLocation("02-Jan-2006 15:04:05.000", fields[0]+" "+fields[1], time.Local); err == nil {
+			return t, true
+		}
+	}
+	for _, format := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.000"} {
+		if t, err := time.ParseInLocation(format, fields[0], time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Returns the first name the client address resolves to, or the address
+// itself if the lookup fails
+func ReverseLookup(client string) string {
+	if names, dnsErr := net.LookupAddr(client); dnsErr == nil && len(names) > 0 {
+		return strings.TrimSuffix(names[0], ".")
+	}
+	return client
 }
@@ -1,7 +1,9 @@
 package util
 
 import (
+	"regexp"
 	"testing"
+	"time"
 )
 
 func TestLogMatcherPositive(t *testing.T) {
@@ -23,3 +25,61 @@ func TestLogMatcherPositive(t *testing.T) {
 		t.Fatalf(`Expected query type of A but got '%s'`, info.QueryType)
 	}
 }
+
+func TestParseBindTimestamp(t *testing.T) {
+	expected := time.Date(2021, time.June, 5, 7, 24, 47, 780000000, time.Local)
+	for _, line := range []string{
+		"05-Jun-2021 07:24:47.780 queries: info: client @0xadfc0030 192.168.0.123#59542 (bitnebula.com): query: bitnebula.com IN A + (192.168.0.456)",
+		"2021-06-05T07:24:47.780 queries: info: client @0xadfc0030 192.168.0.123#59542 (bitnebula.com): query: bitnebula.com IN A + (192.168.0.456)",
+	} {
+		ts, ok := ParseBindTimestamp(line)
+		if !ok || !ts.Equal(expected) {
+			t.Fatalf("Expected %s from `%s` but got %s", expected, line, ts)
+		}
+	}
+
+	if _, ok := ParseBindTimestamp("client @0xadfc0030 192.168.0.123#59542"); ok {
+		t.Fatalf("Expected no timestamp from a line without one")
+	}
+}
+
+func TestLogMatcherNamedGroups(t *testing.T) {
+	line := "05-Jun-2021 07:24:47.780 queries: info: client @0xadfc0030 192.168.0.123#59542 (bitnebula.com): view internal: query: bitnebula.com IN A + (192.168.0.456)"
+	matcher := LogMatcher{
+		Regex:  regexp.MustCompile(`^(?P<timestamp>\S+ \S+) (?P<category>\w+):.*client(?: @0x[0-9a-f]+)? (?P<client>[^\s#]+)#(?P<port>\d+).*view (?P<view>[^:]+): query: (?P<name>\S+) (?P<class>\S+) (?P<type>\S+) (?P<flags>\S+) \((?P<server>[^)]+)\)`),
+		Labels: []string{"view", "category"},
+	}
+	info := matcher.Match(line, map[string]string{"instance": "ns1"})
+
+	if !info.Matched {
+		t.Fatalf("No match detected in known-good string with named groups")
+	}
+	for field, expected := range map[string]string{
+		"client":    "192.168.0.123",
+		"name":      "bitnebula.com",
+		"type":      "A",
+		"class":     "IN",
+		"flags":     "+",
+		"server":    "192.168.0.456",
+		"view":      "internal",
+		"port":      "59542",
+		"timestamp": "05-Jun-2021 07:24:47.780",
+		"category":  "queries",
+	} {
+		if value := info.Field(field); value != expected {
+			t.Fatalf(`Expected %s of %s but got '%s'`, field, expected, value)
+		}
+	}
+	if info.Labels["view"] != "internal" || info.Labels["category"] != "queries" || info.Labels["instance"] != "ns1" {
+		t.Fatalf("Expected labels instance=ns1,view=internal,category=queries but got %v", info.Labels)
+	}
+
+	/* Named and unnamed groups mixed: the unnamed ones are client, name and type */
+	matcher = LogMatcher{
+		Regex: regexp.MustCompile(`client(?: @0x[0-9a-f]+)? ([^\s#]+)#(?P<port>\d+).*query: ([^\s]+) (?P<class>\S+) ([^\s]+)`),
+	}
+	info = matcher.ExtractInfo(line)
+	if info.QueryClient != "192.168.0.123" || info.QueryName != "bitnebula.com" || info.QueryType != "A" || info.QueryClass != "IN" || info.QueryPort != "59542" {
+		t.Fatalf("Unexpected fields from a mixed pattern: %+v", info)
+	}
+}
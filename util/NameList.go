@@ -0,0 +1,226 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// A NameList matches DNS names against a list of entries, one of
+//
+//	www.example.com   exactly that name
+//	.example.com      example.com and every name below it
+//	*.ads.example     every name below ads.example, but not ads.example
+//	ads?.example.com  a name where each label matches the glob of its label
+//	re:^ns[0-9]+\.    names the regular expression matches anywhere in
+//
+// Names are compared without case and without a trailing dot. Everything but
+// the regular expressions lives in a trie of labels in reverse order, so a
+// lookup costs the same with a handful of entries as with hundreds of
+// thousands.
+type NameList struct {
+	root    nameNode
+	regexes []*regexp.Regexp
+	size    int
+}
+
+/* Each kind of entry ending at a node keeps its text, for MatchEntry */
+type nameNode struct {
+	children   map[string]*nameNode
+	globs      []nameGlob
+	exact      string // the name ending here
+	suffix     string // the name ending here and everything below it
+	subdomains string // everything below, but not the name ending here
+}
+
+type nameGlob struct {
+	pattern string
+	node    *nameNode
+}
+
+func NewNameList() *NameList {
+	return &NameList{}
+}
+
+// Reads a list with one entry per line. Blank lines and lines starting with
+// # are skipped.
+func LoadNameList(fileName string) (*NameList, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := NewNameList()
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if err := list.Add(entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", fileName, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (l *NameList) Add(entry string) error {
+	if strings.HasPrefix(entry, "re:") {
+		re, err := regexp.Compile(entry[len("re:"):])
+		if err != nil {
+			return err
+		}
+		l.regexes = append(l.regexes, re)
+		l.size++
+		return nil
+	}
+
+	name := normalizeName(entry)
+	var mark func(*nameNode)
+	switch {
+	case name == "*":
+		l.root.suffix = entry
+		l.size++
+		return nil
+	case strings.HasPrefix(name, "."):
+		name = name[1:]
+		mark = func(n *nameNode) { n.suffix = entry }
+	case strings.HasPrefix(name, "*."):
+		name = name[2:]
+		mark = func(n *nameNode) { n.subdomains = entry }
+	default:
+		mark = func(n *nameNode) { n.exact = entry }
+	}
+
+	node := &l.root
+	for name != "" {
+		var label string
+		label, name = lastLabel(name)
+		if label == "" {
+			return fmt.Errorf("`%s` has an empty label", entry)
+		}
+		if strings.ContainsAny(label, "*?[") {
+			if _, err := path.Match(label, ""); err != nil {
+				return fmt.Errorf("`%s` is not a valid glob: %s", entry, err)
+			}
+			node = node.glob(label)
+			continue
+		}
+		node = node.child(label)
+	}
+	mark(node)
+	l.size++
+	return nil
+}
+
+// The number of entries in the list
+func (l *NameList) Len() int {
+	if l == nil {
+		return 0
+	}
+	return l.size
+}
+
+func (l *NameList) Match(name string) bool {
+	_, matched := l.MatchEntry(name)
+	return matched
+}
+
+// The entry a name matches, as it was added. When several entries match,
+// the most specific one is returned, and regular expressions come last.
+func (l *NameList) MatchEntry(name string) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+
+	name = normalizeName(name)
+	if entry := l.root.match(name); entry != "" {
+		return entry, true
+	}
+	for _, re := range l.regexes {
+		if re.MatchString(name) {
+			return "re:" + re.String(), true
+		}
+	}
+	return "", false
+}
+
+func (n *nameNode) child(label string) *nameNode {
+	if n.children == nil {
+		n.children = make(map[string]*nameNode)
+	}
+	child, ok := n.children[label]
+	if !ok {
+		child = &nameNode{}
+		n.children[label] = child
+	}
+	return child
+}
+
+func (n *nameNode) glob(pattern string) *nameNode {
+	for _, glob := range n.globs {
+		if glob.pattern == pattern {
+			return glob.node
+		}
+	}
+	child := &nameNode{}
+	n.globs = append(n.globs, nameGlob{pattern: pattern, node: child})
+	return child
+}
+
+// The entry the rest of a name, with the labels that led to this node
+// already taken off its end, matches, or "" if none. Entries further down
+// are more specific and win.
+func (n *nameNode) match(rest string) string {
+	if rest == "" {
+		if n.exact != "" {
+			return n.exact
+		}
+		return n.suffix
+	}
+
+	label, below := lastLabel(rest)
+	if child, ok := n.children[label]; ok {
+		if entry := child.match(below); entry != "" {
+			return entry
+		}
+	}
+	for _, glob := range n.globs {
+		if matched, _ := path.Match(glob.pattern, label); matched {
+			if entry := glob.node.match(below); entry != "" {
+				return entry
+			}
+		}
+	}
+
+	if n.subdomains != "" {
+		return n.subdomains
+	}
+	return n.suffix
+}
+
+func lastLabel(name string) (string, string) {
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 {
+		return name, ""
+	}
+	return name[i+1:], name[:i]
+}
+
+func normalizeName(name string) string {
+	if name != "." {
+		name = strings.TrimSuffix(name, ".")
+	} else {
+		name = ""
+	}
+	return strings.ToLower(name)
+}
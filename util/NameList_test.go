@@ -0,0 +1,120 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func nameList(t testing.TB, entries ...string) *NameList {
+	list := NewNameList()
+	for _, entry := range entries {
+		if err := list.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return list
+}
+
+func TestNameList(t *testing.T) {
+	list := nameList(t,
+		"www.example.com",
+		".example.org",
+		"*.ads.example",
+		"cdn?.example.net",
+		"*.tracker-*.example.net",
+		`re:^ns[0-9]+\.`,
+	)
+
+	for name, expected := range map[string]bool{
+		"www.example.com":          true,
+		"WWW.Example.COM.":         true,
+		"example.com":              false,
+		"mail.www.example.com":     false,
+		"example.org":              true,
+		"www.example.org":          true,
+		"a.b.example.org":          true,
+		"badexample.org":           false,
+		"ads.example":              false,
+		"x.ads.example":            true,
+		"x.y.ads.example":          true,
+		"cdn1.example.net":         true,
+		"cdn10.example.net":        false,
+		"a.tracker-eu.example.net": true,
+		"tracker-eu.example.net":   false,
+		"ns1.example.com":          true,
+		"www.ns1.example.com":      false,
+		".":                        false,
+	} {
+		if matched := list.Match(name); matched != expected {
+			t.Fatalf("Expected %s to match %t but got %t", name, expected, matched)
+		}
+	}
+
+	if list.Len() != 6 {
+		t.Fatalf("Expected 6 entries but got %d", list.Len())
+	}
+
+	var empty *NameList
+	if empty.Match("example.com") || empty.Len() != 0 {
+		t.Fatalf("Expected a nil list to be empty")
+	}
+}
+
+func TestNameListMatchEntry(t *testing.T) {
+	list := nameList(t, ".example.com", "www.example.com", "*.cdn.example.com", "img?.cdn.example.com", `re:^ns[0-9]+\.`)
+
+	for name, expected := range map[string]string{
+		"example.com":          ".example.com",
+		"WWW.example.com.":     "www.example.com",
+		"mail.example.com":     ".example.com",
+		"a.cdn.example.com":    "*.cdn.example.com",
+		"img1.cdn.example.com": "img?.cdn.example.com",
+		"cdn.example.com":      ".example.com",
+		"ns1.example.net":      `re:^ns[0-9]+\.`,
+		"example.net":          "",
+	} {
+		if entry, _ := list.MatchEntry(name); entry != expected {
+			t.Errorf("Expected %s to match `%s` but got `%s`", name, expected, entry)
+		}
+	}
+}
+
+func TestLoadNameList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bind_query_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "names.txt")
+	if err := ioutil.WriteFile(path, []byte("# our domains\n.example.com\n\n*.ads.example\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	list, err := LoadNameList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Len() != 2 || !list.Match("www.example.com") || !list.Match("x.ads.example") {
+		t.Fatalf("Unexpected list loaded from file")
+	}
+
+	for _, entry := range []string{"re:(", "ads[.example.com", "www..example.com"} {
+		if err := NewNameList().Add(entry); err == nil {
+			t.Fatalf("Expected an error for `%s`", entry)
+		}
+	}
+}
+
+func BenchmarkNameList(b *testing.B) {
+	list := NewNameList()
+	for i := 0; i < 500000; i++ {
+		list.Add(fmt.Sprintf(".host%d.example%d.com", i, i%1000))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		list.Match(fmt.Sprintf("www.host%d.example%d.com", i%1000000, i%1000))
+	}
+}
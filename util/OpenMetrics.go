@@ -0,0 +1,265 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/matttproud/golang_protobuf_extensions/pbutil"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// How many samples are turned into OpenMetrics at once while writing
+const openMetricsChunk = 1000
+
+// Records every metric of a gatherer at chosen points in time and writes the
+// result as OpenMetrics with timestamps, which `promtool tsdb
+// create-blocks-from openmetrics` can backfill into Prometheus. A replay of
+// months of logs takes many samples, so they are spilled to a temporary file
+// per metric family rather than kept in memory.
+type OpenMetricsRecorder struct {
+	gatherer prometheus.Gatherer
+	families map[string]*recordedFamily
+	order    []string
+}
+
+// The samples of one metric family. Each Record appends a run of them,
+// sorted by series, to the file.
+type recordedFamily struct {
+	header *dto.MetricFamily
+	file   *os.File
+	writer *bufio.Writer
+	size   int64
+	runs   []recordedRun
+}
+
+type recordedRun struct {
+	offset int64
+	length int64
+}
+
+func NewOpenMetricsRecorder(gatherer prometheus.Gatherer) *OpenMetricsRecorder {
+	return &OpenMetricsRecorder{
+		gatherer: gatherer,
+		families: make(map[string]*recordedFamily),
+	}
+}
+
+// Takes a sample of every series, stamped with the given time
+func (r *OpenMetricsRecorder) Record(at time.Time) error {
+	gathered, err := r.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	ms := at.UnixNano() / int64(time.Millisecond)
+	for _, mf := range gathered {
+		family, ok := r.families[mf.GetName()]
+		if !ok {
+			file, err := ioutil.TempFile("", "bind_query_exporter-"+mf.GetName()+"-")
+			if err != nil {
+				return err
+			}
+			family = &recordedFamily{
+				header: &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type},
+				file:   file,
+				writer: bufio.NewWriter(file),
+			}
+			r.families[mf.GetName()] = family
+			r.order = append(r.order, mf.GetName())
+		}
+
+		sortSeries(mf.Metric)
+		run := recordedRun{offset: family.size}
+		for _, m := range mf.Metric {
+			m.TimestampMs = &ms
+			n, err := pbutil.WriteDelimited(family.writer, m)
+			if err != nil {
+				return err
+			}
+			run.length += int64(n)
+		}
+		family.size += run.length
+		family.runs = append(family.runs, run)
+	}
+	return nil
+}
+
+// Writes everything recorded. OpenMetrics wants each series' samples
+// together and in time order, so the runs of every family are merged.
+func (r *OpenMetricsRecorder) Write(w io.Writer) error {
+	for _, name := range r.order {
+		if err := r.families[name].write(w); err != nil {
+			return err
+		}
+	}
+	_, err := expfmt.FinalizeOpenMetrics(w)
+	return err
+}
+
+// Removes the temporary files
+func (r *OpenMetricsRecorder) Close() error {
+	var err error
+	for _, family := range r.families {
+		family.file.Close()
+		if removeErr := os.Remove(family.file.Name()); removeErr != nil {
+			err = removeErr
+		}
+	}
+	r.families = make(map[string]*recordedFamily)
+	r.order = nil
+	return err
+}
+
+func (f *recordedFamily) write(w io.Writer) error {
+	if err := f.writer.Flush(); err != nil {
+		return err
+	}
+
+	merger := &runMerger{}
+	for i, run := range f.runs {
+		reader := bufio.NewReaderSize(io.NewSectionReader(f.file, run.offset, run.length), 1024)
+		if err := merger.add(i, reader); err != nil {
+			return err
+		}
+	}
+	heap.Init(merger)
+
+	/* Only the first chunk keeps the HELP and TYPE lines */
+	var buf bytes.Buffer
+	chunk := &dto.MetricFamily{Name: f.header.Name, Help: f.header.Help, Type: f.header.Type}
+	first := true
+	flush := func() error {
+		if len(chunk.Metric) == 0 {
+			return nil
+		}
+		buf.Reset()
+		if _, err := expfmt.MetricFamilyToOpenMetrics(&buf, chunk); err != nil {
+			return err
+		}
+		out := buf.Bytes()
+		for !first && bytes.HasPrefix(out, []byte("# ")) {
+			out = out[bytes.IndexByte(out, '\n')+1:]
+		}
+		first = false
+		chunk.Metric = chunk.Metric[:0]
+		_, err := w.Write(out)
+		return err
+	}
+
+	for merger.Len() > 0 {
+		next := merger.runs[0]
+		chunk.Metric = append(chunk.Metric, next.metric)
+		if len(chunk.Metric) >= openMetricsChunk {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if err := next.read(); err == io.EOF {
+			heap.Pop(merger)
+		} else if err != nil {
+			return err
+		} else {
+			heap.Fix(merger, 0)
+		}
+	}
+	return flush()
+}
+
+// One run being merged, with the sample it is at
+type mergedRun struct {
+	index  int
+	reader *bufio.Reader
+	metric *dto.Metric
+	key    string
+}
+
+func (m *mergedRun) read() error {
+	metric := &dto.Metric{}
+	if _, err := pbutil.ReadDelimited(m.reader, metric); err != nil {
+		return err
+	}
+	m.metric = metric
+	m.key = seriesKey(metric)
+	return nil
+}
+
+// A heap of runs ordered by their current series, then by when they were
+// recorded, so the samples of a series come out together and in time order
+type runMerger struct {
+	runs []*mergedRun
+}
+
+func (h *runMerger) add(index int, reader *bufio.Reader) error {
+	run := &mergedRun{index: index, reader: reader}
+	if err := run.read(); err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	h.runs = append(h.runs, run)
+	return nil
+}
+
+func (h *runMerger) Len() int { return len(h.runs) }
+
+func (h *runMerger) Less(i, j int) bool {
+	if h.runs[i].key != h.runs[j].key {
+		return h.runs[i].key < h.runs[j].key
+	}
+	return h.runs[i].index < h.runs[j].index
+}
+
+func (h *runMerger) Swap(i, j int) { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+
+func (h *runMerger) Push(x interface{}) { h.runs = append(h.runs, x.(*mergedRun)) }
+
+func (h *runMerger) Pop() interface{} {
+	last := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return last
+}
+
+// Writes the current value of every metric in the text exposition format
+func WriteText(w io.Writer, gatherer prometheus.Gatherer) error {
+	gathered, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	for _, mf := range gathered {
+		if _, err := expfmt.MetricFamilyToText(w, mf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortSeries(metrics []*dto.Metric) {
+	keys := make(map[*dto.Metric]string, len(metrics))
+	for _, m := range metrics {
+		keys[m] = seriesKey(m)
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		return keys[metrics[i]] < keys[metrics[j]]
+	})
+}
+
+// Gather sorts label pairs by name, so joining them identifies the series
+func seriesKey(m *dto.Metric) string {
+	var b strings.Builder
+	for _, pair := range m.GetLabel() {
+		b.WriteString(pair.GetName())
+		b.WriteByte(0xff)
+		b.WriteString(pair.GetValue())
+		b.WriteByte(0xff)
+	}
+	return b.String()
+}
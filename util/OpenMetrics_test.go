@@ -0,0 +1,108 @@
+package util
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestOpenMetricsRecorder(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	names := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "names_total", Help: "Queries by name"}, []string{"name"})
+	queries := prometheus.NewCounter(prometheus.CounterOpts{Name: "queries_total", Help: "Queries"})
+	registry.MustRegister(names, queries)
+
+	/* Enough intervals to write several chunks of every series, with a
+	   series that only appears halfway through */
+	const intervals = 2500
+	recorder := NewOpenMetricsRecorder(registry)
+	start := time.Unix(1622877887, 0)
+	for i := 0; i < intervals; i++ {
+		names.WithLabelValues("bitnebula.com").Inc()
+		if i >= intervals/2 {
+			names.WithLabelValues("example.com").Inc()
+		}
+		queries.Inc()
+		if err := recorder.Record(start.Add(time.Duration(i) * time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, family := range recorder.families {
+		if len(family.header.Metric) != 0 {
+			t.Fatalf("Expected the samples of %s on disk rather than in memory", family.header.GetName())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := recorder.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	files := make([]string, 0, len(recorder.families))
+	for _, family := range recorder.families {
+		files = append(files, family.file.Name())
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Fatalf("Expected %s to be removed on close", file)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if lines[len(lines)-1] != "# EOF" {
+		t.Fatalf("Expected the output to end with # EOF but found `%s`", lines[len(lines)-1])
+	}
+
+	/* Every series is written in one piece, in time order */
+	headers := map[string]int{}
+	samples := map[string]int{}
+	done := map[string]bool{}
+	series := ""
+	last := 0.0
+	for _, line := range lines[:len(lines)-1] {
+		if strings.HasPrefix(line, "# ") {
+			headers[line]++
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			t.Fatalf("Expected a sample with a timestamp but found `%s`", line)
+		}
+		at, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fields[0] != series {
+			if done[fields[0]] {
+				t.Fatalf("Expected the samples of %s together", fields[0])
+			}
+			done[series] = true
+			series = fields[0]
+		} else if at <= last {
+			t.Fatalf("Expected the samples of %s in time order", series)
+		}
+		last = at
+		samples[series]++
+	}
+	for header, count := range headers {
+		if count != 1 {
+			t.Fatalf("Expected `%s` once but found it %d times", header, count)
+		}
+	}
+	for name, expected := range map[string]int{
+		`names_total{name="bitnebula.com"}`: intervals,
+		`names_total{name="example.com"}`:   intervals / 2,
+		`queries_total`:                     intervals,
+	} {
+		if samples[name] != expected {
+			t.Fatalf("Expected %d samples of %s but found %d", expected, name, samples[name])
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package util
+
+import (
+	"container/heap"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// How long the source of a lookup is remembered after the lookup completed,
+// to recognise it in log lines that are read some time after the query
+const ptrSourceGrace = time.Minute
+
+// A PTRClient looks addresses up at one DNS server over UDP, TCP or DNS over
+// TLS, rather than through the system resolver. It remembers the source
+// address and port of each of its lookups for a while, so the exporter can
+// recognise its own queries when the server logs them.
+type PTRClient struct {
+	server  string
+	client  *dns.Client
+	lock    sync.Mutex
+	sources map[string]time.Time // "address#port name" to when it is forgotten
+	expiry  ptrSourceHeap        // the same sources, soonest forgotten first
+}
+
+// A source and the time it was to be forgotten at when it was queued. Sources
+// remembered again are queued again, and the outdated entry is skipped.
+type ptrSourceExpiry struct {
+	source string
+	forget time.Time
+}
+
+type ptrSourceHeap []ptrSourceExpiry
+
+func (h ptrSourceHeap) Len() int            { return len(h) }
+func (h ptrSourceHeap) Less(i, j int) bool  { return h[i].forget.Before(h[j].forget) }
+func (h ptrSourceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *ptrSourceHeap) Push(x interface{}) { *h = append(*h, x.(ptrSourceExpiry)) }
+func (h *ptrSourceHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// Takes the server as [udp://|tcp://|tls://]HOST[:PORT]. The port defaults to
+// 53, or 853 for tls. An empty serverName checks the certificate of a tls
+// server against HOST.
+func NewPTRClient(server string, serverName string, timeout time.Duration) (*PTRClient, error) {
+	network := "udp"
+	port := "53"
+	if i := strings.Index(server, "://"); i >= 0 {
+		switch server[:i] {
+		case "udp":
+		case "tcp":
+			network = "tcp"
+		case "tls":
+			network = "tcp-tls"
+			port = "853"
+		default:
+			return nil, fmt.Errorf("`%s` is not udp, tcp or tls in resolver %s", server[:i], server)
+		}
+		server = server[i+3:]
+	}
+
+	host, p, err := net.SplitHostPort(server)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(server, "["), "]")
+	} else {
+		port = p
+	}
+	if host == "" {
+		return nil, fmt.Errorf("resolver %s has no host", server)
+	}
+
+	client := &dns.Client{
+		Net:     network,
+		Timeout: timeout,
+	}
+	if network == "tcp-tls" {
+		if serverName == "" {
+			serverName = host
+		}
+		client.TLSConfig = &tls.Config{ServerName: serverName}
+	}
+
+	return &PTRClient{
+		server:  net.JoinHostPort(host, port),
+		client:  client,
+		sources: map[string]time.Time{},
+	}, nil
+}
+
+// The host:port lookups are sent to
+func (c *PTRClient) Server() string {
+	return c.server
+}
+
+// Looks up the names of an address, like net.LookupAddr
+func (c *PTRClient) LookupAddr(address string) ([]string, error) {
+	name, err := dns.ReverseAddr(address)
+	if err != nil {
+		return nil, err
+	}
+	msg := &dns.Msg{}
+	msg.SetQuestion(name, dns.TypePTR)
+
+	conn, err := c.client.Dial(c.server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	/* The query may be logged before the reply arrives */
+	if host, port, err := net.SplitHostPort(conn.LocalAddr().String()); err == nil {
+		source := ptrSource(host, port, name)
+		c.remember(source, time.Now().Add(c.client.Timeout+ptrSourceGrace))
+		defer func() { c.remember(source, time.Now().Add(ptrSourceGrace)) }()
+	}
+
+	reply, _, err := c.client.ExchangeWithConn(msg, conn)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("lookup %s on %s: %s", name, c.server, dns.RcodeToString[reply.Rcode])
+	}
+
+	var names []string
+	for _, answer := range reply.Answer {
+		if ptr, ok := answer.(*dns.PTR); ok {
+			names = append(names, ptr.Ptr)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("lookup %s on %s: no PTR record", name, c.server)
+	}
+	return names, nil
+}
+
+// Whether the query came from one of this client's own lookups, going by the
+// client address and port and the name queried
+func (c *PTRClient) Own(info LogMatch) bool {
+	if info.QueryPort == "" {
+		return false
+	}
+	source := ptrSource(info.QueryClient, info.QueryPort, info.QueryName)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	forget, ok := c.sources[source]
+	return ok && time.Now().Before(forget)
+}
+
+// Records until when a source counts as a lookup of ours, and forgets those
+// past their time
+func (c *PTRClient) remember(source string, forget time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	for len(c.expiry) > 0 && now.After(c.expiry[0].forget) {
+		expired := heap.Pop(&c.expiry).(ptrSourceExpiry)
+		if f, ok := c.sources[expired.source]; ok && f.Equal(expired.forget) {
+			delete(c.sources, expired.source)
+		}
+	}
+	c.sources[source] = forget
+	heap.Push(&c.expiry, ptrSourceExpiry{source: source, forget: forget})
+}
+
+func ptrSource(address string, port string, name string) string {
+	if ip := net.ParseIP(address); ip != nil {
+		address = ip.String()
+	}
+	return address + "#" + port + " " + strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,219 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// A DNS server answering PTR queries for 192.168.0.123 only, which records
+// where each query came from the way BIND would log it
+type stubDNSServer struct {
+	server  *dns.Server
+	address string
+	lock    sync.Mutex
+	queries []LogMatch
+}
+
+func startStubDNSServer(t *testing.T, network string, tlsConfig *tls.Config) *stubDNSServer {
+	stub := &stubDNSServer{}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		host, port, _ := net.SplitHostPort(w.RemoteAddr().String())
+		question := r.Question[0]
+		stub.lock.Lock()
+		stub.queries = append(stub.queries, LogMatch{
+			Matched:     true,
+			QueryClient: host,
+			QueryPort:   port,
+			QueryName:   strings.TrimSuffix(question.Name, "."),
+			QueryType:   dns.TypeToString[question.Qtype],
+		})
+		stub.lock.Unlock()
+
+		reply := &dns.Msg{}
+		reply.SetReply(r)
+		if question.Name == "123.0.168.192.in-addr.arpa." {
+			reply.Answer = append(reply.Answer, &dns.PTR{
+				Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 60},
+				Ptr: "host.example.com.",
+			})
+		} else {
+			reply.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(reply)
+	})
+
+	started := make(chan bool)
+	stub.server = &dns.Server{Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stub.server.PacketConn = conn
+		stub.address = conn.LocalAddr().String()
+	} else {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stub.address = listener.Addr().String()
+		if tlsConfig != nil {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+		stub.server.Listener = listener
+	}
+	go stub.server.ActivateAndServe()
+	<-started
+	return stub
+}
+
+func (s *stubDNSServer) Queries() []LogMatch {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]LogMatch{}, s.queries...)
+}
+
+// A self-signed certificate for 127.0.0.1, and a pool that trusts it
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stub"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestNewPTRClient(t *testing.T) {
+	for _, test := range []struct {
+		server   string
+		expected string
+		network  string
+	}{
+		{"10.0.0.53", "10.0.0.53:53", "udp"},
+		{"udp://10.0.0.53:5353", "10.0.0.53:5353", "udp"},
+		{"tcp://ns1.example.com", "ns1.example.com:53", "tcp"},
+		{"tls://dns.example.com", "dns.example.com:853", "tcp-tls"},
+		{"tls://[2001:db8::53]:8853", "[2001:db8::53]:8853", "tcp-tls"},
+		{"[2001:db8::53]", "[2001:db8::53]:53", "udp"},
+	} {
+		client, err := NewPTRClient(test.server, "", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if client.Server() != test.expected || client.client.Net != test.network {
+			t.Fatalf("Expected %s over %s for %s but got %s over %s", test.expected, test.network, test.server, client.Server(), client.client.Net)
+		}
+	}
+
+	for _, server := range []string{"https://dns.example.com", "udp://"} {
+		if _, err := NewPTRClient(server, "", time.Second); err == nil {
+			t.Fatalf("Expected an error for resolver %s", server)
+		}
+	}
+}
+
+func TestPTRClientLookupAddr(t *testing.T) {
+	certificate, pool := testCertificate(t)
+	for _, network := range []string{"udp", "tcp", "tls"} {
+		var tlsConfig *tls.Config
+		if network == "tls" {
+			tlsConfig = &tls.Config{Certificates: []tls.Certificate{certificate}}
+		}
+		stub := startStubDNSServer(t, network, tlsConfig)
+		defer stub.server.Shutdown()
+
+		client, err := NewPTRClient(network+"://"+stub.address, "", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tlsConfig != nil {
+			client.client.TLSConfig.RootCAs = pool
+		}
+
+		names, err := client.LookupAddr("192.168.0.123")
+		if err != nil {
+			t.Fatalf("Lookup over %s failed: %s", network, err)
+		}
+		if len(names) != 1 || names[0] != "host.example.com." {
+			t.Fatalf("Expected host.example.com. over %s but got %v", network, names)
+		}
+		if _, err := client.LookupAddr("192.168.0.124"); err == nil {
+			t.Fatalf("Expected an error over %s for an address without a name", network)
+		}
+
+		/* Both lookups are recognised as our own, and nothing else is */
+		queries := stub.Queries()
+		if len(queries) != 2 {
+			t.Fatalf("Expected 2 queries over %s but the server saw %d", network, len(queries))
+		}
+		for _, query := range queries {
+			if !client.Own(query) {
+				t.Fatalf("Expected the query for %s from %s#%s to be recognised", query.QueryName, query.QueryClient, query.QueryPort)
+			}
+			other := query
+			other.QueryName = "bitnebula.com"
+			if client.Own(other) {
+				t.Fatalf("Expected a query for another name from the same port not to be recognised")
+			}
+			other = query
+			other.QueryPort = "1"
+			if client.Own(other) {
+				t.Fatalf("Expected a query from another port not to be recognised")
+			}
+		}
+	}
+}
+
+func TestPTRClientForget(t *testing.T) {
+	client, err := NewPTRClient("192.168.0.1", "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := LogMatch{QueryClient: "192.168.0.2", QueryPort: "5353", QueryName: "10.0.168.192.in-addr.arpa"}
+	source := ptrSource(query.QueryClient, query.QueryPort, query.QueryName)
+
+	/* Remembering a source again replaces the time it is forgotten at */
+	now := time.Now()
+	client.remember(source, now.Add(-time.Second))
+	client.remember(source, now.Add(time.Hour))
+	client.remember("192.168.0.2#5354 old.example.com", now.Add(-time.Minute))
+	client.remember("192.168.0.2#5355 new.example.com", now)
+	if !client.Own(query) {
+		t.Fatalf("Expected the source to be remembered for another hour")
+	}
+	if len(client.sources) != 2 {
+		t.Fatalf("Expected the sources past their time to be forgotten but found %v", client.sources)
+	}
+}
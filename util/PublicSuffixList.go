@@ -0,0 +1,157 @@
+package util
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// A PublicSuffixList finds the registrable domain (eTLD+1) of names, such as
+// example.co.uk for foo.bar.example.co.uk. It uses the list compiled into the
+// exporter unless it is given a public_suffix_list.dat file, which can be
+// read again with Reload when a newer list is dropped in.
+type PublicSuffixList struct {
+	file  string
+	rules atomic.Value // *suffixRules, nil for the compiled in list
+}
+
+type suffixRules struct {
+	rules      map[string]bool
+	wildcards  map[string]bool // *.ck kept as ck
+	exceptions map[string]bool // !www.ck kept as www.ck
+}
+
+func NewPublicSuffixList(file string) (*PublicSuffixList, error) {
+	l := &PublicSuffixList{file: file}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reads the list file again. The list in use is kept if it fails.
+func (l *PublicSuffixList) Reload() error {
+	if l.file == "" {
+		l.rules.Store((*suffixRules)(nil))
+		return nil
+	}
+	rules, err := loadSuffixRules(l.file)
+	if err != nil {
+		return err
+	}
+	l.rules.Store(rules)
+	return nil
+}
+
+// The list file, for watching it for changes
+func (l *PublicSuffixList) Files() []string {
+	if l.file == "" {
+		return nil
+	}
+	return []string{l.file}
+}
+
+// The registrable domain of the name, or the name itself when it is a public
+// suffix or has no registrable part
+func (l *PublicSuffixList) RegistrableDomain(name string) string {
+	original := name
+	name = normalizeName(name)
+	if name == "" {
+		return original
+	}
+
+	rules := l.rules.Load().(*suffixRules)
+	if rules == nil {
+		domain, err := publicsuffix.EffectiveTLDPlusOne(name)
+		if err != nil {
+			return name
+		}
+		return domain
+	}
+
+	labels := strings.Split(name, ".")
+	suffix := rules.suffixStart(labels)
+	if suffix == 0 {
+		return name
+	}
+	return strings.Join(labels[suffix-1:], ".")
+}
+
+// The index of the first label of the public suffix of a name, by the
+// algorithm of publicsuffix.org: exceptions win, then the longest rule, and
+// the last label when no rule matches
+func (r *suffixRules) suffixStart(labels []string) int {
+	for i := range labels {
+		suffix := strings.Join(labels[i:], ".")
+		if r.exceptions[suffix] {
+			return i + 1
+		}
+		if r.rules[suffix] {
+			return i
+		}
+		if i > 0 && r.wildcards[suffix] {
+			return i - 1
+		}
+	}
+	return len(labels) - 1
+}
+
+func loadSuffixRules(fileName string) (*suffixRules, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rules := &suffixRules{
+		rules:      make(map[string]bool),
+		wildcards:  make(map[string]bool),
+		exceptions: make(map[string]bool),
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		/* A rule is the first word of a line, comments start with // */
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "//") {
+			continue
+		}
+		rule := fields[0]
+		set := rules.rules
+		switch {
+		case strings.HasPrefix(rule, "!"):
+			rule, set = rule[1:], rules.exceptions
+		case strings.HasPrefix(rule, "*."):
+			rule, set = rule[2:], rules.wildcards
+		}
+		/* The list has internationalized names in Unicode, BIND logs them in punycode */
+		if ascii, err := idna.ToASCII(rule); err == nil {
+			rule = ascii
+		}
+		set[strings.ToLower(rule)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// The last depth labels of a name, or the name itself when it has no more
+// than that
+func TruncateName(name string, depth int) string {
+	if name == "." {
+		return name
+	}
+	name = strings.TrimSuffix(name, ".")
+	end := len(name)
+	for i := 0; i < depth; i++ {
+		end = strings.LastIndexByte(name[:end], '.')
+		if end < 0 {
+			return name
+		}
+	}
+	return name[end+1:]
+}
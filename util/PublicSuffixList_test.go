@@ -0,0 +1,53 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestRegistrableDomain(t *testing.T) {
+	compiled, err := NewPublicSuffixList("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := NewPublicSuffixList("testdata/public_suffix_list.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, list := range []*PublicSuffixList{compiled, file} {
+		for name, expected := range map[string]string{
+			"foo.bar.example.co.uk": "example.co.uk",
+			"WWW.Example.COM.":      "example.com",
+			"example.com":           "example.com",
+			"co.uk":                 "co.uk",
+			"user.github.io":        "user.github.io",
+			"a.b.c.ck":              "b.c.ck",
+			"www.www.ck":            "www.ck",
+			"host.corp.internal":    "corp.internal",
+			"shop.xn--55qx5d.cn":    "shop.xn--55qx5d.cn",
+			".":                     ".",
+		} {
+			if domain := list.RegistrableDomain(name); domain != expected {
+				t.Errorf("Expected %s for %s but got %s (list file %q)", expected, name, domain, list.file)
+			}
+		}
+	}
+}
+
+func TestTruncateName(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		depth    int
+		expected string
+	}{
+		{"host.site.corp.internal", 2, "corp.internal"},
+		{"host.site.corp.internal.", 3, "site.corp.internal"},
+		{"corp.internal", 3, "corp.internal"},
+		{"internal", 1, "internal"},
+		{".", 2, "."},
+	} {
+		if name := TruncateName(test.name, test.depth); name != test.expected {
+			t.Errorf("Expected %s for %s at depth %d but got %s", test.expected, test.name, test.depth, name)
+		}
+	}
+}
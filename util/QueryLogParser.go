@@ -0,0 +1,174 @@
+package util
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The fields ParseQueryLine fills in, by their capture group name
+var QueryLogParserFields = []string{"client", "name", "type", "class", "flags", "server", "view", "port", "ecs"}
+
+// The flags block BIND logs after the query type, such as +E(0)K
+type QueryFlags struct {
+	Recursion        bool // +
+	Signed           bool // S - TSIG or SIG(0)
+	EDNS             bool // E or E(n)
+	EDNSVersion      int  // -1 when BIND does not log it
+	TCP              bool // T
+	DNSSECOK         bool // D
+	CheckingDisabled bool // C
+	Cookie           bool // K - a client cookie only
+	ValidCookie      bool // V - a valid server cookie
+}
+
+// Parses a query line in any of the formats of BIND 9:
+//
+//	9.9:  client 192.168.0.123#59542: view internal: query: bitnebula.com IN A + (192.168.0.456)
+//	9.10: client 192.168.0.123#59542 (bitnebula.com): query: bitnebula.com IN A + (192.168.0.456)
+//	9.11: client @0xadfc0030 192.168.0.123#59542 (bitnebula.com): view internal: query: bitnebula.com IN A +E(0)K (192.168.0.456) [ECS 192.168.0.0/24/0]
+//
+// with or without the time, category and severity BIND may print in front.
+// Lines that are not queries, such as "query (cache) denied", do not match.
+func ParseQueryLine(line string) LogMatch {
+	result := LogMatch{Matched: false}
+
+	rest, ok := afterClient(line)
+	if !ok {
+		return result
+	}
+
+	/* Newer versions print the address of the client object first */
+	if strings.HasPrefix(rest, "@0x") {
+		space := strings.IndexByte(rest, ' ')
+		if space < 0 {
+			return result
+		}
+		rest = rest[space+1:]
+	}
+
+	hash := strings.IndexByte(rest, '#')
+	if hash <= 0 {
+		return result
+	}
+	result.QueryClient = rest[:hash]
+	rest = rest[hash+1:]
+
+	end := 0
+	for end < len(rest) && rest[end] >= '0' && rest[end] <= '9' {
+		end++
+	}
+	if end == 0 {
+		return result
+	}
+	result.QueryPort = rest[:end]
+	rest = rest[end:]
+
+	query := strings.Index(rest, ": query: ")
+	if query < 0 {
+		return result
+	}
+	result.QueryView = viewOf(rest[:query])
+	rest = rest[query+len(": query: "):]
+
+	/* name class type flags (server) [ECS subnet] */
+	result.QueryName, rest = nextField(rest)
+	result.QueryClass, rest = nextField(rest)
+	result.QueryType, rest = nextField(rest)
+	if result.QueryType == "" {
+		return result
+	}
+	result.QueryFlags, rest = nextField(rest)
+
+	server, rest := nextField(rest)
+	if len(server) > 2 && server[0] == '(' && server[len(server)-1] == ')' {
+		result.QueryServer = server[1 : len(server)-1]
+	}
+	if ecs, _ := nextField(rest); ecs == "[ECS" {
+		_, rest = nextField(rest)
+		subnet, _ := nextField(rest)
+		result.QueryECS = strings.TrimSuffix(subnet, "]")
+	}
+
+	result.Matched = true
+	return result
+}
+
+// Parses the flags block of a query line. Unknown flags are ignored so newer
+// versions of BIND do not break the parsing of the ones that are known.
+func ParseQueryFlags(flags string) (QueryFlags, bool) {
+	result := QueryFlags{}
+	if flags == "" || (flags[0] != '+' && flags[0] != '-') {
+		return result, false
+	}
+	result.Recursion = flags[0] == '+'
+
+	for i := 1; i < len(flags); i++ {
+		switch flags[i] {
+		case 'S':
+			result.Signed = true
+		case 'E':
+			result.EDNS = true
+			result.EDNSVersion = -1
+			if i+1 < len(flags) && flags[i+1] == '(' {
+				end := strings.IndexByte(flags[i:], ')')
+				if end < 0 {
+					return result, false
+				}
+				version, err := strconv.Atoi(flags[i+2 : i+end])
+				if err != nil {
+					return result, false
+				}
+				result.EDNSVersion = version
+				i += end
+			}
+		case 'T':
+			result.TCP = true
+		case 'D':
+			result.DNSSECOK = true
+		case 'C':
+			result.CheckingDisabled = true
+		case 'K':
+			result.Cookie = true
+		case 'V':
+			result.ValidCookie = true
+		}
+	}
+	return result, true
+}
+
+// Finds the "client " that starts the query part of a line and returns what
+// follows it
+func afterClient(line string) (string, bool) {
+	offset := 0
+	for {
+		i := strings.Index(line[offset:], "client ")
+		if i < 0 {
+			return "", false
+		}
+		i += offset
+		if i == 0 || line[i-1] == ' ' {
+			return line[i+len("client "):], true
+		}
+		offset = i + 1
+	}
+}
+
+// The view in what BIND prints between the client port and "query:", which
+// is ": view NAME" in 9.9 and " (NAME): view NAME" later on
+func viewOf(between string) string {
+	if strings.HasPrefix(between, ": view ") {
+		return between[len(": view "):]
+	}
+	if i := strings.LastIndex(between, "): view "); i >= 0 {
+		return between[i+len("): view "):]
+	}
+	return ""
+}
+
+func nextField(s string) (string, string) {
+	s = strings.TrimLeft(s, " ")
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
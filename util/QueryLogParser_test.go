@@ -0,0 +1,152 @@
+package util
+
+import (
+	"bufio"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func readCorpus(t testing.TB) []string {
+	file, err := os.Open("testdata/queries.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func TestParseQueryLineCorpus(t *testing.T) {
+	for _, line := range readCorpus(t) {
+		if info := ParseQueryLine(line); !info.Matched {
+			t.Fatalf("No match detected in corpus line `%s`", line)
+		}
+	}
+}
+
+// The parser replaced the default pattern, so it must count the same client,
+// name and type. These are the only lines on which they are meant to differ.
+var defaultPatternDifferences = map[string]bool{
+	/* Classes other than IN are counted by the parser only */
+	"05-Jun-2021 07:24:48.500 queries: info: client @0x7f1c441a6c50 127.0.0.1#33045 (version.bind): query: version.bind CH TXT + (127.0.0.1)":                    true,
+	"17-Oct-2021 10:02:12.001 queries: info: client @0x7f0a2c0f1168 192.0.2.62#42000 (hostname.bind): view chaos: query: hostname.bind CH TXT -E(0) (192.0.2.1)": true,
+	/* Lines BIND does not write were counted by the pattern only */
+	"05-Jun-2021 07:24:47.780 queries: info: client @0x7f1c441a6c50 192.0.2.50: query: example.com IN A + (192.0.2.1)":       true,
+	"05-Jun-2021 07:24:47.780 queries: info: subclient 192.0.2.50#4000 (example.com): query: example.com IN A + (192.0.2.1)": true,
+}
+
+func TestParseQueryLineDefaultPattern(t *testing.T) {
+	matcher := NewLogMatcher()
+	lines := append(readCorpus(t), parseQueryLineNegative...)
+	for _, line := range lines {
+		info := ParseQueryLine(line)
+		expected := matcher.ExtractInfo(line)
+		if info.Matched != expected.Matched {
+			if !defaultPatternDifferences[line] {
+				t.Fatalf("Expected match %t from `%s` as with the default pattern but got %t", expected.Matched, line, info.Matched)
+			}
+			continue
+		}
+		if defaultPatternDifferences[line] {
+			t.Fatalf("Expected `%s` to be read differently from the default pattern", line)
+		}
+		if info.Matched && (info.QueryClient != expected.QueryClient || info.QueryName != expected.QueryName || info.QueryType != expected.QueryType) {
+			t.Fatalf("Expected %s %s %s from `%s` but got %s %s %s", expected.QueryClient, expected.QueryName, expected.QueryType, line, info.QueryClient, info.QueryName, info.QueryType)
+		}
+	}
+}
+
+func TestParseQueryLineFields(t *testing.T) {
+	for line, expected := range map[string]LogMatch{
+		"05-Jun-2021 07:24:48.013 queries: info: client @0x7f1c441a6c50 2001:db8::15#53211 (ipv6.example.com): view external: query: ipv6.example.com IN AAAA -E(0)TK (2001:db8::1)": {
+			QueryClient: "2001:db8::15", QueryPort: "53211", QueryView: "external", QueryName: "ipv6.example.com",
+			QueryClass: "IN", QueryType: "AAAA", QueryFlags: "-E(0)TK", QueryServer: "2001:db8::1",
+		},
+		"05-Jun-2021 07:24:48.121 queries: info: client @0x7f1c441a6c50 203.0.113.7#10054 (example.org): query: example.org IN A +E(0)K (198.51.100.53) [ECS 203.0.113.0/24/0]": {
+			QueryClient: "203.0.113.7", QueryPort: "10054", QueryName: "example.org",
+			QueryClass: "IN", QueryType: "A", QueryFlags: "+E(0)K", QueryServer: "198.51.100.53", QueryECS: "203.0.113.0/24/0",
+		},
+		"17-Oct-2021 10:02:11.402 queries: info: client @0x7f0a2c0f1168 2001:db8:85a3::8a2e:370:7334#40712 (www.example.com): view external: query: www.example.com IN AAAA -E(0)DC (2001:db8::53) [ECS 2001:db8:85a3::/56/0]": {
+			QueryClient: "2001:db8:85a3::8a2e:370:7334", QueryPort: "40712", QueryView: "external", QueryName: "www.example.com",
+			QueryClass: "IN", QueryType: "AAAA", QueryFlags: "-E(0)DC", QueryServer: "2001:db8::53", QueryECS: "2001:db8:85a3::/56/0",
+		},
+		"05-Jun-2021 07:24:49.202 client 192.0.2.48#1026: view internal: query: example.net IN A +E (192.0.2.1)": {
+			QueryClient: "192.0.2.48", QueryPort: "1026", QueryView: "internal", QueryName: "example.net",
+			QueryClass: "IN", QueryType: "A", QueryFlags: "+E", QueryServer: "192.0.2.1",
+		},
+		"05-Jun-2021 07:24:48.500 queries: info: client @0x7f1c441a6c50 127.0.0.1#33045 (version.bind): query: version.bind CH TXT + (127.0.0.1)": {
+			QueryClient: "127.0.0.1", QueryPort: "33045", QueryName: "version.bind",
+			QueryClass: "CH", QueryType: "TXT", QueryFlags: "+", QueryServer: "127.0.0.1",
+		},
+	} {
+		expected.Matched = true
+		if info := ParseQueryLine(line); !reflect.DeepEqual(info, expected) {
+			t.Fatalf("Expected %+v from `%s` but got %+v", expected, line, info)
+		}
+	}
+}
+
+var parseQueryLineNegative = []string{
+	"",
+	"05-Jun-2021 07:24:47.780 general: info: zone example.com/IN: loaded serial 2021060501",
+	"05-Jun-2021 07:24:47.780 security: info: client @0x7f1c441a6c50 192.0.2.50#4000 (example.com): query (cache) 'example.com/A/IN' denied",
+	"05-Jun-2021 07:24:47.780 queries: info: client @0x7f1c441a6c50 192.0.2.50: query: example.com IN A + (192.0.2.1)",
+	"05-Jun-2021 07:24:47.780 queries: info: client @0x7f1c441a6c50 192.0.2.50#4000 (example.com): query: example.com",
+	"05-Jun-2021 07:24:47.780 queries: info: subclient 192.0.2.50#4000 (example.com): query: example.com IN A + (192.0.2.1)",
+}
+
+func TestParseQueryLineNegative(t *testing.T) {
+	for _, line := range parseQueryLineNegative {
+		if info := ParseQueryLine(line); info.Matched {
+			t.Fatalf("Expected no match from `%s` but got %+v", line, info)
+		}
+	}
+}
+
+func TestParseQueryFlags(t *testing.T) {
+	for flags, expected := range map[string]QueryFlags{
+		"+":        {Recursion: true},
+		"-":        {},
+		"+E":       {Recursion: true, EDNS: true, EDNSVersion: -1},
+		"+E(0)K":   {Recursion: true, EDNS: true, Cookie: true},
+		"-SE(1)TD": {Signed: true, EDNS: true, EDNSVersion: 1, TCP: true, DNSSECOK: true},
+		"+E(0)DCV": {Recursion: true, EDNS: true, DNSSECOK: true, CheckingDisabled: true, ValidCookie: true},
+	} {
+		parsed, ok := ParseQueryFlags(flags)
+		if !ok || parsed != expected {
+			t.Fatalf("Expected %+v from `%s` but got %+v", expected, flags, parsed)
+		}
+	}
+
+	for _, flags := range []string{"", "E(0)", "+E(", "+E(x)"} {
+		if _, ok := ParseQueryFlags(flags); ok {
+			t.Fatalf("Expected `%s` to be rejected", flags)
+		}
+	}
+}
+
+func BenchmarkParseQueryLine(b *testing.B) {
+	lines := readCorpus(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ParseQueryLine(lines[i%len(lines)])
+	}
+}
+
+func BenchmarkLogMatcherDefaultPattern(b *testing.B) {
+	lines := readCorpus(b)
+	matcher := NewLogMatcher()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matcher.ExtractInfo(lines[i%len(lines)])
+	}
+}
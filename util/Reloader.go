@@ -0,0 +1,246 @@
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// Changes to watched files often come in bursts (write, chmod, rename), so a
+// reload waits for them to settle
+const reloadSettleTime = 500 * time.Millisecond
+
+type reloadable struct {
+	name   string
+	reload func() error
+	files  map[string]bool
+}
+
+// A Reloader reads the files of every registered consumer again on request,
+// for example on SIGHUP or through its HTTP handler. When a file changes,
+// only the consumers that read it are reloaded.
+type Reloader struct {
+	what            string
+	lock            sync.Mutex
+	reloadables     []reloadable
+	files           map[string]bool
+	watcher         *fsnotify.Watcher
+	successMetric   prometheus.Gauge
+	timestampMetric prometheus.Gauge
+	reloadsMetric   *prometheus.CounterVec
+}
+
+// The metrics go in the subsystem, and what is reloaded, such as
+// "include/exclude lists", is named in their help and the log
+func NewReloader(namespace string, subsystem string, what string) *Reloader {
+	successMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "last_successful",
+			Help:      "Whether the last reload of the " + what + " succeeded (1) or failed (0)",
+		},
+	)
+	successMetric.Set(1)
+
+	timestampMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Time the " + what + " were last loaded successfully",
+		},
+	)
+	timestampMetric.SetToCurrentTime()
+
+	reloadsMetric := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "total",
+			Help:      "Reloads of the " + what + " by result",
+		},
+		[]string{"result"},
+	)
+	reloadsMetric.WithLabelValues("success").Add(0)
+	reloadsMetric.WithLabelValues("failure").Add(0)
+
+	return &Reloader{
+		what:            what,
+		files:           make(map[string]bool),
+		successMetric:   successMetric,
+		timestampMetric: timestampMetric,
+		reloadsMetric:   reloadsMetric,
+	}
+}
+
+// Registers a reload function and the files it reads. Must be called before
+// Watch.
+func (r *Reloader) Add(name string, reload func() error, files ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	owned := make(map[string]bool)
+	for _, file := range files {
+		if abs, err := filepath.Abs(file); err == nil {
+			r.files[abs] = true
+			owned[abs] = true
+		}
+	}
+	r.reloadables = append(r.reloadables, reloadable{name: name, reload: reload, files: owned})
+}
+
+// Runs every reload function, one reload at a time. Each one keeps what it
+// had loaded if it fails, and the errors are returned together.
+func (r *Reloader) Reload() error {
+	return r.reload(nil)
+}
+
+// Runs the reload functions of the consumers reading any of the changed
+// files, or of all of them without changed files
+func (r *Reloader) reload(changed map[string]bool) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var errs []string
+	for _, reloadable := range r.reloadables {
+		if changed != nil && !reloadable.owns(changed) {
+			continue
+		}
+		if err := reloadable.reload(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", reloadable.name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		r.successMetric.Set(0)
+		r.reloadsMetric.WithLabelValues("failure").Inc()
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	r.successMetric.Set(1)
+	r.timestampMetric.SetToCurrentTime()
+	r.reloadsMetric.WithLabelValues("success").Inc()
+	log.Infoln("Reloaded the", r.what)
+	return nil
+}
+
+func (r reloadable) owns(files map[string]bool) bool {
+	for file := range files {
+		if r.files[file] {
+			return true
+		}
+	}
+	return false
+}
+
+// Reloads whenever one of the registered files changes. The directories are
+// watched rather than the files, so lists replaced by a rename (as most
+// editors and configuration management do) are noticed as well.
+func (r *Reloader) Watch() error {
+	if len(r.files) == 0 {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	dirs := make(map[string]bool)
+	for file := range r.files {
+		dirs[filepath.Dir(file)] = true
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+	r.watcher = watcher
+
+	go func() {
+		var settle <-chan time.Time
+		changed := make(map[string]bool)
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				file := filepath.Clean(event.Name)
+				if r.files[file] && event.Op != fsnotify.Chmod {
+					log.Debugln("File changed:", event)
+					changed[file] = true
+					settle = time.After(reloadSettleTime)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorln("Failed to watch the", r.what+":", err)
+			case <-settle:
+				settle = nil
+				if err := r.reload(changed); err != nil {
+					log.Errorln("Failed to reload the", r.what, "after a file changed:", err)
+				}
+				changed = make(map[string]bool)
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *Reloader) Close() error {
+	if r.watcher == nil {
+		return nil
+	}
+	return r.watcher.Close()
+}
+
+// Reloads on POST or PUT, the way Prometheus' own /-/reload does
+func (r *Reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ReloadHandler(r)(w, req)
+}
+
+// Reloads every reloader on POST or PUT. Each one counts its own failures,
+// and the request fails if any of them did.
+func ReloadHandler(reloaders ...*Reloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost && req.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, "Only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var errs []string
+		for _, r := range reloaders {
+			if err := r.Reload(); err != nil {
+				log.Errorln("Failed to reload the", r.what, "on request from", req.RemoteAddr, err)
+				errs = append(errs, fmt.Sprintf("%s: %s", r.what, err))
+			}
+		}
+		if len(errs) > 0 {
+			http.Error(w, fmt.Sprintf("Failed to reload: %s", strings.Join(errs, "; ")), http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("OK\n"))
+	}
+}
+
+func (r *Reloader) Collect(ch chan<- prometheus.Metric) {
+	r.successMetric.Collect(ch)
+	r.timestampMetric.Collect(ch)
+	r.reloadsMetric.Collect(ch)
+}
+
+func (r *Reloader) Describe(ch chan<- *prometheus.Desc) {
+	r.successMetric.Describe(ch)
+	r.timestampMetric.Describe(ch)
+	r.reloadsMetric.Describe(ch)
+}
@@ -0,0 +1,141 @@
+package util
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReloaderMetrics(t *testing.T) {
+	reloader := NewReloader("test", "reload", "lists")
+	var failure error
+	reloader.Add("names", func() error { return failure })
+
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	failure = errors.New("no such file")
+	if err := reloader.Reload(); err == nil || err.Error() != "names: no such file" {
+		t.Fatalf("Expected the error of the reload function but got %v", err)
+	}
+
+	if value := testutil.ToFloat64(reloader.successMetric); value != 0 {
+		t.Fatalf("Expected the last reload to be marked as failed but got %f", value)
+	}
+	if value := testutil.ToFloat64(reloader.reloadsMetric.WithLabelValues("success")); value != 1 {
+		t.Fatalf("Expected 1 successful reload but got %f", value)
+	}
+	if value := testutil.ToFloat64(reloader.reloadsMetric.WithLabelValues("failure")); value != 1 {
+		t.Fatalf("Expected 1 failed reload but got %f", value)
+	}
+}
+
+func TestReloaderHTTP(t *testing.T) {
+	reloader := NewReloader("test", "reload", "lists")
+	reloads := 0
+	reloader.Add("names", func() error { reloads++; return nil })
+
+	recorder := httptest.NewRecorder()
+	reloader.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/-/reload", nil))
+	if recorder.Code != http.StatusMethodNotAllowed || reloads != 0 {
+		t.Fatalf("Expected a GET to be refused without reloading but got %d after %d reloads", recorder.Code, reloads)
+	}
+
+	recorder = httptest.NewRecorder()
+	reloader.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+	if recorder.Code != http.StatusOK || reloads != 1 {
+		t.Fatalf("Expected a POST to reload but got %d after %d reloads", recorder.Code, reloads)
+	}
+
+	/* A failing reloader fails the request, and the others still reload */
+	failing := NewReloader("test", "client_names_reload", "client name files")
+	failing.Add("leases", func() error { return errors.New("unreadable") })
+	recorder = httptest.NewRecorder()
+	ReloadHandler(failing, reloader)(recorder, httptest.NewRequest(http.MethodPut, "/-/reload", nil))
+	if recorder.Code != http.StatusInternalServerError || reloads != 2 {
+		t.Fatalf("Expected a failed reload to return 500 but got %d after %d reloads", recorder.Code, reloads)
+	}
+	if failures := testutil.ToFloat64(failing.reloadsMetric.WithLabelValues("failure")); failures != 1 {
+		t.Fatalf("Expected 1 failed reload of the client names but found %f", failures)
+	}
+}
+
+func TestReloaderWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "include.txt")
+	if err := ioutil.WriteFile(file, []byte("example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reloader := NewReloader("test", "reload", "lists")
+	reloaded := make(chan bool, 1)
+	reloader.Add("names", func() error { reloaded <- true; return nil }, file)
+	if err := reloader.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	/* Replace the file the way editors do */
+	if err := ioutil.WriteFile(file+".new", []byte("example.net\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(file+".new", file); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("The list was not reloaded after it changed")
+	}
+}
+
+func TestReloaderWatchReloadsOwner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	include := filepath.Join(dir, "include.txt")
+	exclude := filepath.Join(dir, "exclude.txt")
+	for _, file := range []string{include, exclude} {
+		if err := ioutil.WriteFile(file, []byte("example.com\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reloader := NewReloader("test", "reload", "lists")
+	included := make(chan bool, 1)
+	excluded := make(chan bool, 1)
+	reloader.Add("include", func() error { included <- true; return nil }, include)
+	reloader.Add("exclude", func() error { excluded <- true; return nil }, exclude)
+	if err := reloader.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	if err := ioutil.WriteFile(include, []byte("example.net\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-included:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("The changed list was not reloaded")
+	}
+	select {
+	case <-excluded:
+		t.Fatalf("A list that did not change was reloaded")
+	case <-time.After(2 * reloadSettleTime):
+	}
+}
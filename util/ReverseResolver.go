@@ -0,0 +1,303 @@
+package util
+
+import (
+	"container/list"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// A ReverseResolver is the ClientIdentity of DNS. It turns client addresses
+// into names without ever holding up the caller. Results are kept in an LRU
+// cache, failed lookups included, so each client is looked up at most once
+// per TTL. Addresses that are not cached are handed to a bounded pool of
+// workers and have no name until the lookup completes. Entries past their TTL keep being
+// served while they are looked up again. Without workers, lookups are made
+// by the caller instead.
+type ReverseResolver struct {
+	size        int
+	positiveTTL time.Duration
+	negativeTTL time.Duration
+	lookup      func(string) ([]string, error)
+	client      *PTRClient
+	lock        sync.Mutex
+	entries     map[string]*list.Element
+	order       *list.List // most recently used first
+	pending     map[string]bool
+	queue       chan string
+	limit       <-chan time.Time
+	ticker      *time.Ticker
+	synchronous bool
+	workers     sync.WaitGroup
+	closed      bool
+
+	hitsMetric     *prometheus.CounterVec
+	missesMetric   prometheus.Counter
+	droppedMetric  prometheus.Counter
+	ownMetric      prometheus.Counter
+	durationMetric *prometheus.HistogramVec
+	entriesDesc    *prometheus.Desc
+}
+
+type reverseEntry struct {
+	address string
+	name    string // empty when the lookup failed
+	expires time.Time
+}
+
+// Number of addresses that may wait for a worker before new ones are dropped
+// and looked up again on a later query
+const reverseQueueSize = 1024
+
+// Lookups go to the system resolver without a client. A rate of 0 does not
+// limit the lookups per second.
+func NewReverseResolver(namespace string, client *PTRClient, size int, positiveTTL time.Duration, negativeTTL time.Duration, workers int, rate float64) *ReverseResolver {
+	hitsMetric := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "reverse_lookup",
+			Name:      "cache_hits_total",
+			Help:      "Client addresses found in the reverse lookup cache, by whether the cached lookup had 'found' a name or 'failed'",
+		},
+		[]string{"result"},
+	)
+	hitsMetric.WithLabelValues("found").Add(0)
+	hitsMetric.WithLabelValues("failed").Add(0)
+
+	missesMetric := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "reverse_lookup",
+			Name:      "cache_misses_total",
+			Help:      "Client addresses not in the reverse lookup cache, or past their TTL. The address is used until the lookup completes",
+		},
+	)
+
+	droppedMetric := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "reverse_lookup",
+			Name:      "dropped_total",
+			Help:      "Reverse lookups not queued because all workers were busy and the queue was full",
+		},
+	)
+
+	ownMetric := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "reverse_lookup",
+			Name:      "own_queries_total",
+			Help:      "Queries in the log recognised as the exporter's own reverse lookups, and not counted by any collector",
+		},
+	)
+
+	durationMetric := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "reverse_lookup",
+			Name:      "duration_seconds",
+			Help:      "Time taken by reverse lookups, by whether they 'found' a name or 'failed'",
+			Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+		[]string{"result"},
+	)
+
+	entriesDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "reverse_lookup", "cache_entries"),
+		"Client addresses currently in the reverse lookup cache",
+		nil, nil,
+	)
+
+	r := &ReverseResolver{
+		size:           size,
+		positiveTTL:    positiveTTL,
+		negativeTTL:    negativeTTL,
+		lookup:         net.LookupAddr,
+		client:         client,
+		entries:        map[string]*list.Element{},
+		order:          list.New(),
+		pending:        map[string]bool{},
+		queue:          make(chan string, reverseQueueSize),
+		hitsMetric:     hitsMetric,
+		missesMetric:   missesMetric,
+		droppedMetric:  droppedMetric,
+		ownMetric:      ownMetric,
+		durationMetric: durationMetric,
+		entriesDesc:    entriesDesc,
+		synchronous:    workers < 1,
+	}
+	if client != nil {
+		r.lookup = client.LookupAddr
+	}
+	if rate > 0 {
+		r.ticker = time.NewTicker(time.Duration(float64(time.Second) / rate))
+		r.limit = r.ticker.C
+	}
+	for i := 0; i < workers; i++ {
+		r.workers.Add(1)
+		go r.work()
+	}
+	return r
+}
+
+// Returns the cached name of the client address, or "" if it has no name or
+// has not been looked up yet. Never blocks on a lookup unless there are no
+// workers.
+func (r *ReverseResolver) Lookup(address string) string {
+	r.lock.Lock()
+	name, fresh := r.cached(address)
+	if !fresh {
+		r.missesMetric.Inc()
+		if !r.synchronous {
+			r.enqueue(address)
+		}
+	}
+	r.lock.Unlock()
+
+	if !fresh && r.synchronous {
+		name = r.resolve(address)
+	}
+	return name
+}
+
+// Returns the cached name of an address, and whether it is within its TTL.
+// Must be called with the lock held.
+func (r *ReverseResolver) cached(address string) (string, bool) {
+	element, ok := r.entries[address]
+	if !ok {
+		return "", false
+	}
+	entry := element.Value.(*reverseEntry)
+	r.order.MoveToFront(element)
+	if !time.Now().Before(entry.expires) {
+		return entry.name, false
+	}
+	if entry.name == "" {
+		r.hitsMetric.WithLabelValues("failed").Inc()
+	} else {
+		r.hitsMetric.WithLabelValues("found").Inc()
+	}
+	return entry.name, true
+}
+
+// Queues a lookup unless one for the address is already under way. Must be
+// called with the lock held.
+func (r *ReverseResolver) enqueue(address string) {
+	if r.pending[address] || r.closed {
+		return
+	}
+	select {
+	case r.queue <- address:
+		r.pending[address] = true
+	default:
+		r.droppedMetric.Inc()
+	}
+}
+
+func (r *ReverseResolver) work() {
+	defer r.workers.Done()
+	for address := range r.queue {
+		if r.limit != nil {
+			<-r.limit
+		}
+
+		r.resolve(address)
+	}
+}
+
+// Looks an address up and caches the result
+func (r *ReverseResolver) resolve(address string) string {
+	start := time.Now()
+	name := ""
+	result := "failed"
+	if names, err := r.lookup(address); err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+		result = "found"
+	} else if err != nil {
+		log.Debugf("Reverse lookup of %s failed: %s", address, err)
+	}
+	r.durationMetric.WithLabelValues(result).Observe(time.Since(start).Seconds())
+
+	r.store(address, name)
+	return name
+}
+
+// Whether the query is one of the resolver's own lookups, which must not be
+// counted or looked up in turn
+func (r *ReverseResolver) Own(info LogMatch) bool {
+	if r.client == nil || !r.client.Own(info) {
+		return false
+	}
+	r.ownMetric.Inc()
+	return true
+}
+
+// Caches the result of a lookup and evicts the least recently used entries
+// beyond the size of the cache
+func (r *ReverseResolver) store(address string, name string) {
+	ttl := r.positiveTTL
+	if name == "" {
+		ttl = r.negativeTTL
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.pending, address)
+
+	entry := &reverseEntry{address: address, name: name, expires: time.Now().Add(ttl)}
+	if element, ok := r.entries[address]; ok {
+		element.Value = entry
+		r.order.MoveToFront(element)
+	} else {
+		r.entries[address] = r.order.PushFront(entry)
+	}
+	for r.order.Len() > r.size {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.entries, oldest.Value.(*reverseEntry).address)
+	}
+}
+
+// Stops the workers once the lookups already queued are done
+func (r *ReverseResolver) Close() error {
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.queue)
+	r.lock.Unlock()
+
+	r.workers.Wait()
+	if r.ticker != nil {
+		r.ticker.Stop()
+	}
+	return nil
+}
+
+func (r *ReverseResolver) Collect(ch chan<- prometheus.Metric) {
+	r.lock.Lock()
+	entries := r.order.Len()
+	r.lock.Unlock()
+
+	r.hitsMetric.Collect(ch)
+	r.missesMetric.Collect(ch)
+	r.droppedMetric.Collect(ch)
+	r.ownMetric.Collect(ch)
+	r.durationMetric.Collect(ch)
+	ch <- prometheus.MustNewConstMetric(r.entriesDesc, prometheus.GaugeValue, float64(entries))
+}
+
+func (r *ReverseResolver) Describe(ch chan<- *prometheus.Desc) {
+	r.hitsMetric.Describe(ch)
+	r.missesMetric.Describe(ch)
+	r.droppedMetric.Describe(ch)
+	r.ownMetric.Describe(ch)
+	r.durationMetric.Describe(ch)
+	ch <- r.entriesDesc
+}
@@ -0,0 +1,170 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// A resolver whose lookups answer from a map and count how often each
+// address was looked up
+func testResolver(size int, positiveTTL time.Duration, negativeTTL time.Duration, names map[string]string) (*ReverseResolver, map[string]int, *sync.Mutex) {
+	resolver := NewReverseResolver("test", nil, size, positiveTTL, negativeTTL, 1, 0)
+	lookups := map[string]int{}
+	lock := &sync.Mutex{}
+	resolver.lookup = func(address string) ([]string, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		lookups[address]++
+		if name, ok := names[address]; ok {
+			return []string{name + "."}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	return resolver, lookups, lock
+}
+
+// Waits until nothing is queued or being looked up
+func settle(t *testing.T, resolver *ReverseResolver) {
+	for i := 0; i < 200; i++ {
+		resolver.lock.Lock()
+		pending := len(resolver.pending)
+		resolver.lock.Unlock()
+		if pending == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Lookups did not complete")
+}
+
+func TestReverseResolverCaches(t *testing.T) {
+	resolver, lookups, lock := testResolver(10, time.Hour, time.Hour, map[string]string{"192.168.0.1": "host.example.com"})
+	defer resolver.Close()
+
+	if name := resolver.Lookup("192.168.0.1"); name != "" {
+		t.Fatalf("Expected no name before the lookup completed but got '%s'", name)
+	}
+	settle(t, resolver)
+	if name := resolver.Lookup("192.168.0.1"); name != "host.example.com" {
+		t.Fatalf("Expected host.example.com but got '%s'", name)
+	}
+
+	/* Failed lookups are cached as well */
+	resolver.Lookup("192.168.0.2")
+	settle(t, resolver)
+	if name := resolver.Lookup("192.168.0.2"); name != "" {
+		t.Fatalf("Expected no name for a client without one but got '%s'", name)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if lookups["192.168.0.1"] != 1 || lookups["192.168.0.2"] != 1 {
+		t.Fatalf("Expected one lookup per address but found %v", lookups)
+	}
+	if hits := testutil.ToFloat64(resolver.hitsMetric.WithLabelValues("found")); hits != 1 {
+		t.Fatalf("Expected 1 found hit but found %f", hits)
+	}
+	if hits := testutil.ToFloat64(resolver.hitsMetric.WithLabelValues("failed")); hits != 1 {
+		t.Fatalf("Expected 1 failed hit but found %f", hits)
+	}
+	if misses := testutil.ToFloat64(resolver.missesMetric); misses != 2 {
+		t.Fatalf("Expected 2 misses but found %f", misses)
+	}
+}
+
+func TestReverseResolverExpires(t *testing.T) {
+	resolver, lookups, lock := testResolver(10, time.Hour, time.Nanosecond, map[string]string{"192.168.0.1": "host.example.com"})
+	defer resolver.Close()
+
+	resolver.Lookup("192.168.0.2")
+	settle(t, resolver)
+	resolver.Lookup("192.168.0.2")
+	settle(t, resolver)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if lookups["192.168.0.2"] != 2 {
+		t.Fatalf("Expected a failed lookup to be made again after the negative TTL but found %d lookups", lookups["192.168.0.2"])
+	}
+}
+
+func TestReverseResolverServesStaleNames(t *testing.T) {
+	names := map[string]string{"192.168.0.1": "host.example.com"}
+	resolver, _, lock := testResolver(10, time.Nanosecond, time.Hour, names)
+	defer resolver.Close()
+
+	resolver.Lookup("192.168.0.1")
+	settle(t, resolver)
+
+	/* The client lost its name, but the old one is used until the lookup
+	   made again completes */
+	lock.Lock()
+	delete(names, "192.168.0.1")
+	lock.Unlock()
+	if name := resolver.Lookup("192.168.0.1"); name != "host.example.com" {
+		t.Fatalf("Expected the expired name while it is looked up again but got '%s'", name)
+	}
+	settle(t, resolver)
+	if name := resolver.Lookup("192.168.0.1"); name != "" {
+		t.Fatalf("Expected no name once the name was gone but got '%s'", name)
+	}
+}
+
+func TestReverseResolverEvicts(t *testing.T) {
+	resolver, _, _ := testResolver(2, time.Hour, time.Hour, map[string]string{})
+	defer resolver.Close()
+
+	for _, address := range []string{"192.168.0.1", "192.168.0.2"} {
+		resolver.Lookup(address)
+		settle(t, resolver)
+	}
+	/* Using .1 again makes .2 the least recently used */
+	resolver.Lookup("192.168.0.1")
+	resolver.Lookup("192.168.0.3")
+	settle(t, resolver)
+
+	if _, ok := resolver.entries["192.168.0.2"]; ok {
+		t.Fatalf("Expected the least recently used address to be evicted")
+	}
+	if _, ok := resolver.entries["192.168.0.1"]; !ok {
+		t.Fatalf("Expected a recently used address to stay cached")
+	}
+	if entries := resolver.order.Len(); entries != 2 {
+		t.Fatalf("Expected 2 cached addresses but found %d", entries)
+	}
+}
+
+func TestReverseResolverDoesNotBlock(t *testing.T) {
+	resolver := NewReverseResolver("test", nil, 10, time.Hour, time.Hour, 1, 0)
+	release := make(chan bool)
+	resolver.lookup = func(address string) ([]string, error) {
+		<-release
+		return []string{"host.example.com."}, nil
+	}
+
+	/* The only worker is stuck, so the queue fills up and the rest is
+	   dropped rather than waited for */
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < reverseQueueSize+10; i++ {
+			resolver.Lookup(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Lookup blocked on a lookup")
+	}
+
+	if dropped := testutil.ToFloat64(resolver.droppedMetric); dropped < 1 {
+		t.Fatalf("Expected dropped lookups but found %f", dropped)
+	}
+	close(release)
+	resolver.Close()
+}
@@ -0,0 +1,192 @@
+package util
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// A SeriesExpirer deletes the series of counter vectors that have not been
+// incremented for a while, so names and clients that are no longer queried
+// do not stay in memory and in every scrape forever
+type SeriesExpirer struct {
+	ttl           time.Duration
+	lock          sync.Mutex
+	vectors       []*ExpiringCounterVec
+	expiredMetric *prometheus.CounterVec
+	done          chan struct{}
+}
+
+// A CounterVec that records when each of its series was last incremented.
+// Without an expirer it is a plain CounterVec.
+type ExpiringCounterVec struct {
+	*prometheus.CounterVec
+	name       string
+	labelNames []string
+	lock       sync.Mutex
+	lastSeen   map[string]expiringSeries
+}
+
+type expiringSeries struct {
+	values []string
+	at     time.Time
+}
+
+func NewSeriesExpirer(namespace string, ttl time.Duration) *SeriesExpirer {
+	expiredMetric := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "series",
+			Name:      "expired_total",
+			Help:      "Series deleted because they were not incremented for longer than the series TTL",
+		},
+		[]string{"metric"},
+	)
+
+	return &SeriesExpirer{
+		ttl:           ttl,
+		expiredMetric: expiredMetric,
+		done:          make(chan struct{}),
+	}
+}
+
+// Wraps a counter vector, keyed by its fully qualified metric name, so its
+// idle series are deleted. A nil expirer wraps it without expiring anything.
+func (e *SeriesExpirer) Wrap(name string, vec *prometheus.CounterVec, labelNames []string) *ExpiringCounterVec {
+	wrapped := &ExpiringCounterVec{CounterVec: vec, name: name, labelNames: labelNames}
+	if e == nil {
+		return wrapped
+	}
+
+	wrapped.lastSeen = make(map[string]expiringSeries)
+	e.lock.Lock()
+	e.vectors = append(e.vectors, wrapped)
+	e.expiredMetric.WithLabelValues(name).Add(0)
+	e.lock.Unlock()
+	return wrapped
+}
+
+// Returns the counter of the label values and marks it as used
+func (v *ExpiringCounterVec) WithLabelValues(values ...string) prometheus.Counter {
+	if v.lastSeen == nil {
+		return v.CounterVec.WithLabelValues(values...)
+	}
+
+	key := strings.Join(values, "\xff")
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	series, ok := v.lastSeen[key]
+	if !ok {
+		series.values = append([]string{}, values...)
+	}
+	series.at = time.Now()
+	v.lastSeen[key] = series
+	return v.CounterVec.WithLabelValues(values...)
+}
+
+// Starts recording when each series was last incremented without an
+// expirer, for a SeriesLimiter to count them
+func (v *ExpiringCounterVec) track() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.lastSeen == nil {
+		v.lastSeen = make(map[string]expiringSeries)
+	}
+}
+
+// Whether the label values joined into the key have a series
+func (v *ExpiringCounterVec) seen(key string) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	_, ok := v.lastSeen[key]
+	return ok
+}
+
+// The number of series recorded
+func (v *ExpiringCounterVec) count() int {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return len(v.lastSeen)
+}
+
+// Deletes the series idle since before the cutoff and returns how many
+func (v *ExpiringCounterVec) expire(cutoff time.Time) int {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.discover()
+	expired := 0
+	for key, series := range v.lastSeen {
+		if series.at.Before(cutoff) {
+			v.CounterVec.DeleteLabelValues(series.values...)
+			delete(v.lastSeen, key)
+			expired++
+		}
+	}
+	return expired
+}
+
+// Records the series created behind our back, such as those restored from a
+// snapshot, as of now. Must be called with the lock held.
+func (v *ExpiringCounterVec) discover() {
+	if series, err := collectSeries(v.CounterVec); err == nil && len(series) > len(v.lastSeen) {
+		now := time.Now()
+		for _, entry := range series {
+			values := make([]string, len(v.labelNames))
+			for i, name := range v.labelNames {
+				values[i] = entry.Labels[name]
+			}
+			key := strings.Join(values, "\xff")
+			if _, ok := v.lastSeen[key]; !ok {
+				v.lastSeen[key] = expiringSeries{values: values, at: now}
+			}
+		}
+	}
+}
+
+// Looks for idle series every half TTL until Close is called
+func (e *SeriesExpirer) Start() {
+	go func() {
+		ticker := time.NewTicker(e.ttl / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				e.Expire()
+			case <-e.done:
+				return
+			}
+		}
+	}()
+}
+
+// Deletes every series not incremented within the TTL
+func (e *SeriesExpirer) Expire() {
+	e.lock.Lock()
+	vectors := e.vectors
+	e.lock.Unlock()
+
+	cutoff := time.Now().Add(-e.ttl)
+	for _, vec := range vectors {
+		if expired := vec.expire(cutoff); expired > 0 {
+			log.Debugln("Expired", expired, "series of", vec.name)
+			e.expiredMetric.WithLabelValues(vec.name).Add(float64(expired))
+		}
+	}
+}
+
+func (e *SeriesExpirer) Close() error {
+	close(e.done)
+	return nil
+}
+
+func (e *SeriesExpirer) Collect(ch chan<- prometheus.Metric) {
+	e.expiredMetric.Collect(ch)
+}
+
+func (e *SeriesExpirer) Describe(ch chan<- *prometheus.Desc) {
+	e.expiredMetric.Describe(ch)
+}
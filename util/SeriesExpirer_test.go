@@ -0,0 +1,49 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestCounterVec() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_names_all", Help: "test"}, []string{"name"})
+}
+
+func TestSeriesExpirer(t *testing.T) {
+	expirer := NewSeriesExpirer("test", time.Hour)
+	vec := expirer.Wrap("test_names_all", newTestCounterVec(), []string{"name"})
+
+	vec.WithLabelValues("old.example.com").Inc()
+	vec.WithLabelValues("new.example.com").Inc()
+	/* Restored from a snapshot, so not seen by the wrapper */
+	vec.CounterVec.WithLabelValues("restored.example.com").Add(5)
+
+	/* Pretend the first one was last queried two hours ago */
+	vec.lastSeen["old.example.com"] = expiringSeries{values: []string{"old.example.com"}, at: time.Now().Add(-2 * time.Hour)}
+
+	expirer.Expire()
+	if count := testutil.CollectAndCount(vec); count != 2 {
+		t.Fatalf("Expected 2 series to be left but found %d", count)
+	}
+	if expired := testutil.ToFloat64(expirer.expiredMetric.WithLabelValues("test_names_all")); expired != 1 {
+		t.Fatalf("Expected 1 expired series but found %f", expired)
+	}
+
+	/* Incrementing an expired series starts it over */
+	vec.WithLabelValues("old.example.com").Inc()
+	if value := testutil.ToFloat64(vec.WithLabelValues("old.example.com")); value != 1 {
+		t.Fatalf("Expected the expired series to start over at 1 but found %f", value)
+	}
+}
+
+func TestSeriesExpirerDisabled(t *testing.T) {
+	var expirer *SeriesExpirer
+	vec := expirer.Wrap("test_names_all", newTestCounterVec(), []string{"name"})
+	vec.WithLabelValues("example.com").Inc()
+	if vec.lastSeen != nil || testutil.CollectAndCount(vec) != 1 {
+		t.Fatalf("Expected a plain counter vector without an expirer")
+	}
+}
@@ -0,0 +1,137 @@
+package util
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The label value of the series that takes the increments of new label
+// values once a counter vector has reached its maximum number of series
+const SeriesOverflow = "__overflow__"
+
+// A SeriesLimiter caps the number of series of counter vectors with a label
+// per name or client. Once a vector has reached its maximum, new label values
+// are folded into an overflow series, so a flood of random names cannot
+// exhaust memory. With a SeriesExpirer, idle series make room again as they
+// expire.
+type SeriesLimiter struct {
+	lock           sync.Mutex
+	vectors        []*LimitedCounterVec
+	overflowMetric *prometheus.CounterVec
+	seriesDesc     *prometheus.Desc
+}
+
+// An ExpiringCounterVec that stops creating series at its maximum. Without a
+// limiter it is the ExpiringCounterVec as it is.
+type LimitedCounterVec struct {
+	*ExpiringCounterVec
+	maxSeries   int
+	overflow    []string
+	overflowKey string
+	limiter     *SeriesLimiter
+	lock        sync.Mutex
+}
+
+func NewSeriesLimiter(namespace string) *SeriesLimiter {
+	overflowMetric := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "series",
+			Name:      "overflow_total",
+			Help:      "Increments folded into the " + SeriesOverflow + " series because the metric had reached its maximum number of series",
+		},
+		[]string{"metric"},
+	)
+
+	seriesDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "series", "current"),
+		"Series a metric currently has, not counting the "+SeriesOverflow+" series",
+		[]string{"metric"}, nil,
+	)
+
+	return &SeriesLimiter{
+		overflowMetric: overflowMetric,
+		seriesDesc:     seriesDesc,
+	}
+}
+
+// Caps a counter vector wrapped by a SeriesExpirer. A nil limiter or a
+// maxSeries of 0 wraps it without limiting anything, and without keeping
+// track of its series or exporting them.
+func (l *SeriesLimiter) Wrap(vec *ExpiringCounterVec, maxSeries int) *LimitedCounterVec {
+	wrapped := &LimitedCounterVec{ExpiringCounterVec: vec}
+	if l == nil || maxSeries <= 0 {
+		return wrapped
+	}
+
+	wrapped.maxSeries = maxSeries
+	wrapped.overflow = make([]string, len(vec.labelNames))
+	for i := range wrapped.overflow {
+		wrapped.overflow[i] = SeriesOverflow
+	}
+	wrapped.overflowKey = strings.Join(wrapped.overflow, "\xff")
+	wrapped.limiter = l
+	/* Series are counted the same way the expirer keeps track of them */
+	vec.track()
+
+	l.lock.Lock()
+	l.vectors = append(l.vectors, wrapped)
+	l.overflowMetric.WithLabelValues(vec.name).Add(0)
+	l.lock.Unlock()
+	return wrapped
+}
+
+// Returns the counter of the label values, or the overflow counter if the
+// label values are new and the vector is full
+func (v *LimitedCounterVec) WithLabelValues(values ...string) prometheus.Counter {
+	if v.limiter == nil {
+		return v.ExpiringCounterVec.WithLabelValues(values...)
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if !v.seen(strings.Join(values, "\xff")) && v.series() >= v.maxSeries {
+		v.limiter.overflowMetric.WithLabelValues(v.name).Inc()
+		values = v.overflow
+	}
+	return v.ExpiringCounterVec.WithLabelValues(values...)
+}
+
+// The number of series, not counting the overflow series
+func (v *LimitedCounterVec) series() int {
+	series := v.count()
+	if v.seen(v.overflowKey) {
+		series--
+	}
+	return series
+}
+
+// Counts the series that already exist, such as those restored from a
+// snapshot, towards the maximum
+func (l *SeriesLimiter) Start() {
+	for _, vec := range l.wrapped() {
+		vec.ExpiringCounterVec.lock.Lock()
+		vec.discover()
+		vec.ExpiringCounterVec.lock.Unlock()
+	}
+}
+
+func (l *SeriesLimiter) wrapped() []*LimitedCounterVec {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.vectors
+}
+
+func (l *SeriesLimiter) Collect(ch chan<- prometheus.Metric) {
+	for _, vec := range l.wrapped() {
+		ch <- prometheus.MustNewConstMetric(l.seriesDesc, prometheus.GaugeValue, float64(vec.series()), vec.name)
+	}
+	l.overflowMetric.Collect(ch)
+}
+
+func (l *SeriesLimiter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- l.seriesDesc
+	l.overflowMetric.Describe(ch)
+}
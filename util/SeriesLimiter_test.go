@@ -0,0 +1,77 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSeriesLimiterOverflow(t *testing.T) {
+	limiter := NewSeriesLimiter("test")
+	var expirer *SeriesExpirer
+	vec := limiter.Wrap(expirer.Wrap("test_names_all", newTestCounterVec(), []string{"name"}), 2)
+	/* Restored from a snapshot, so not seen by the wrapper */
+	vec.CounterVec.WithLabelValues("restored.example.com").Add(5)
+	limiter.Start()
+
+	for _, name := range []string{"a.example.com", "b.example.com", "restored.example.com", "a.example.com"} {
+		vec.WithLabelValues(name).Inc()
+	}
+
+	if value := testutil.ToFloat64(vec.CounterVec.WithLabelValues("restored.example.com")); value != 6 {
+		t.Fatalf("Expected a restored series to keep counting but found %f", value)
+	}
+	if value := testutil.ToFloat64(vec.CounterVec.WithLabelValues("a.example.com")); value != 2 {
+		t.Fatalf("Expected a series below the maximum to keep counting but found %f", value)
+	}
+	if value := testutil.ToFloat64(vec.CounterVec.WithLabelValues(SeriesOverflow)); value != 1 {
+		t.Fatalf("Expected 1 increment in the overflow series but found %f", value)
+	}
+	if overflowed := testutil.ToFloat64(limiter.overflowMetric.WithLabelValues("test_names_all")); overflowed != 1 {
+		t.Fatalf("Expected 1 overflowed increment but found %f", overflowed)
+	}
+	if series := vec.series(); series != 2 {
+		t.Fatalf("Expected 2 series but found %d", series)
+	}
+}
+
+func TestSeriesLimiterExpiry(t *testing.T) {
+	expirer := NewSeriesExpirer("test", time.Hour)
+	limiter := NewSeriesLimiter("test")
+	vec := limiter.Wrap(expirer.Wrap("test_names_all", newTestCounterVec(), []string{"name"}), 1)
+	limiter.Start()
+
+	vec.WithLabelValues("old.example.com").Inc()
+	vec.WithLabelValues("new.example.com").Inc()
+	if value := testutil.ToFloat64(vec.CounterVec.WithLabelValues(SeriesOverflow)); value != 1 {
+		t.Fatalf("Expected the full vector to overflow but found %f", value)
+	}
+
+	/* An expired series makes room for a new one */
+	vec.lastSeen["old.example.com"] = expiringSeries{values: []string{"old.example.com"}, at: time.Now().Add(-2 * time.Hour)}
+	expirer.Expire()
+	vec.WithLabelValues("new.example.com").Inc()
+	if value := testutil.ToFloat64(vec.CounterVec.WithLabelValues("new.example.com")); value != 1 {
+		t.Fatalf("Expected a new series once an idle one expired but found %f", value)
+	}
+}
+
+func TestSeriesLimiterDisabled(t *testing.T) {
+	var limiter *SeriesLimiter
+	var expirer *SeriesExpirer
+	vec := limiter.Wrap(expirer.Wrap("test_names_all", newTestCounterVec(), []string{"name"}), 1)
+	vec.WithLabelValues("a.example.com").Inc()
+	vec.WithLabelValues("b.example.com").Inc()
+	if vec.lastSeen != nil || testutil.CollectAndCount(vec) != 2 {
+		t.Fatalf("Expected a plain counter vector without a limiter")
+	}
+
+	/* A limiter does not look after vectors without a maximum */
+	limiter = NewSeriesLimiter("test")
+	vec = limiter.Wrap(expirer.Wrap("test_names_all", newTestCounterVec(), []string{"name"}), 0)
+	vec.WithLabelValues("a.example.com").Inc()
+	if vec.lastSeen != nil || testutil.CollectAndCount(limiter) != 0 {
+		t.Fatalf("Expected a vector without a maximum to be neither tracked nor exported")
+	}
+}
@@ -0,0 +1,121 @@
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+type snapshotSeries struct {
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// A Snapshot keeps the values of counter vectors in a file so they survive a
+// restart of the exporter instead of starting over at zero
+type Snapshot struct {
+	path     string
+	counters map[string]*prometheus.CounterVec
+}
+
+func NewSnapshot(path string) *Snapshot {
+	return &Snapshot{
+		path:     path,
+		counters: make(map[string]*prometheus.CounterVec),
+	}
+}
+
+// Adds counter vectors, keyed by their fully qualified metric name
+func (s *Snapshot) Register(counters map[string]*prometheus.CounterVec) {
+	for name, vec := range counters {
+		s.counters[name] = vec
+	}
+}
+
+// Adds the saved values to the registered counters. Series whose labels no
+// longer fit the vector (for example after enabling capture-client) and
+// metrics that are no longer registered are skipped.
+func (s *Snapshot) Restore() error {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	saved := make(map[string][]snapshotSeries)
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+
+	restored := 0
+	for name, series := range saved {
+		vec, ok := s.counters[name]
+		if !ok {
+			log.Infoln("Not restoring", name, "- metric is not enabled")
+			continue
+		}
+		for _, entry := range series {
+			counter, err := vec.GetMetricWith(entry.Labels)
+			if err != nil {
+				log.Debugln("Not restoring", name, entry.Labels, err)
+				continue
+			}
+			counter.Add(entry.Value)
+			restored++
+		}
+	}
+	log.Infoln("Restored", restored, "series from", s.path)
+	return nil
+}
+
+// Writes the current value of every series of the registered counters
+func (s *Snapshot) Save() error {
+	saved := make(map[string][]snapshotSeries)
+	for name, vec := range s.counters {
+		series, err := collectSeries(vec)
+		if err != nil {
+			return err
+		}
+		saved[name] = series
+	}
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(s.path, data)
+}
+
+func collectSeries(vec *prometheus.CounterVec) ([]snapshotSeries, error) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		vec.Collect(ch)
+		close(ch)
+	}()
+
+	var series []snapshotSeries
+	var err error
+	for metric := range ch {
+		m := &dto.Metric{}
+		if writeErr := metric.Write(m); writeErr != nil {
+			err = writeErr
+			continue
+		}
+		entry := snapshotSeries{
+			Labels: make(map[string]string),
+			Value:  m.GetCounter().GetValue(),
+		}
+		for _, pair := range m.GetLabel() {
+			entry.Labels[pair.GetName()] = pair.GetValue()
+		}
+		series = append(series, entry)
+	}
+
+	return series, err
+}
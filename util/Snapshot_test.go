@@ -0,0 +1,62 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func counterValue(t *testing.T, vec *prometheus.CounterVec, labels ...string) float64 {
+	m := &dto.Metric{}
+	if err := vec.WithLabelValues(labels...).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bind_query_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot.json")
+
+	names := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "names"}, []string{"name"})
+	names.WithLabelValues("bitnebula.com").Add(3)
+	names.WithLabelValues("example.com").Add(1)
+
+	snapshot := NewSnapshot(path)
+	snapshot.Register(map[string]*prometheus.CounterVec{"names": names})
+	if err := snapshot.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	/* A fresh vector that has already counted something since startup */
+	restored := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "names"}, []string{"name"})
+	restored.WithLabelValues("bitnebula.com").Add(1)
+	changed := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "names"}, []string{"name", "client"})
+
+	snapshot = NewSnapshot(path)
+	snapshot.Register(map[string]*prometheus.CounterVec{"names": restored})
+	if err := snapshot.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if v := counterValue(t, restored, "bitnebula.com"); v != 4 {
+		t.Fatalf("Expected bitnebula.com to be 4 after restore but got %f", v)
+	}
+	if v := counterValue(t, restored, "example.com"); v != 1 {
+		t.Fatalf("Expected example.com to be 1 after restore but got %f", v)
+	}
+
+	/* Series that no longer fit the labels are skipped, not fatal */
+	snapshot = NewSnapshot(path)
+	snapshot.Register(map[string]*prometheus.CounterVec{"names": changed})
+	if err := snapshot.Restore(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,115 @@
+package util
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// A TopK counts the most frequent keys of a stream in bounded memory with
+// the Space-Saving algorithm (Metwally et al., 2005). It monitors at most
+// capacity keys; a new key takes over the counter of the least counted one
+// and inherits its count as its error. The count of a key is never below its
+// true count and at most Error above it, and every key queried more than
+// total/capacity times is guaranteed to be monitored.
+type TopK struct {
+	lock     sync.Mutex
+	capacity int
+	items    map[string]*topKItem
+	heap     topKHeap
+	total    float64
+}
+
+// An estimated count of a key. Count-Error is a lower bound of the true count.
+type TopKEntry struct {
+	Key   string
+	Count float64
+	Error float64
+}
+
+type topKItem struct {
+	TopKEntry
+	index int
+}
+
+func NewTopK(capacity int) *TopK {
+	return &TopK{
+		capacity: capacity,
+		items:    make(map[string]*topKItem, capacity),
+	}
+}
+
+func (t *TopK) Add(key string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.total++
+	if item, ok := t.items[key]; ok {
+		item.Count++
+		heap.Fix(&t.heap, item.index)
+		return
+	}
+
+	if len(t.heap) < t.capacity {
+		item := &topKItem{TopKEntry: TopKEntry{Key: key, Count: 1}}
+		t.items[key] = item
+		heap.Push(&t.heap, item)
+		return
+	}
+
+	/* Replace the least counted key */
+	item := t.heap[0]
+	delete(t.items, item.Key)
+	item.Key = key
+	item.Error = item.Count
+	item.Count++
+	t.items[key] = item
+	heap.Fix(&t.heap, 0)
+}
+
+// The k keys with the highest counts, highest first, and the number of keys
+// added in total
+func (t *TopK) Top(k int) ([]TopKEntry, float64) {
+	t.lock.Lock()
+	entries := make([]TopKEntry, 0, len(t.heap))
+	for _, item := range t.heap {
+		entries = append(entries, item.TopKEntry)
+	}
+	total := t.total
+	t.lock.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	if len(entries) > k {
+		entries = entries[:k]
+	}
+	return entries, total
+}
+
+// A min-heap of the monitored keys by count
+type topKHeap []*topKItem
+
+func (h topKHeap) Len() int           { return len(h) }
+func (h topKHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topKHeap) Push(x interface{}) {
+	item := x.(*topKItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,74 @@
+package util
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestTopK(t *testing.T) {
+	top := NewTopK(20)
+
+	/* A few heavy hitters in a long tail of names queried once or twice */
+	random := rand.New(rand.NewSource(1))
+	truth := make(map[string]float64)
+	for i := 0; i < 20000; i++ {
+		var key string
+		switch n := random.Intn(100); {
+		case n < 30:
+			key = "heavy1.example.com"
+		case n < 50:
+			key = "heavy2.example.com"
+		case n < 60:
+			key = "heavy3.example.com"
+		default:
+			key = fmt.Sprintf("tail%d.example.com", random.Intn(5000))
+		}
+		truth[key]++
+		top.Add(key)
+	}
+
+	entries, total := top.Top(3)
+	if total != 20000 {
+		t.Fatalf("Expected a total of 20000 but got %f", total)
+	}
+	for i, expected := range []string{"heavy1.example.com", "heavy2.example.com", "heavy3.example.com"} {
+		entry := entries[i]
+		if entry.Key != expected {
+			t.Fatalf("Expected %s at %d but got %s", expected, i, entry.Key)
+		}
+		if entry.Count < truth[entry.Key] || entry.Count-entry.Error > truth[entry.Key] {
+			t.Errorf("Expected the true count %f of %s between %f and %f", truth[entry.Key], entry.Key, entry.Count-entry.Error, entry.Count)
+		}
+	}
+}
+
+func TestTopKUnderCapacity(t *testing.T) {
+	top := NewTopK(10)
+	for _, key := range []string{"a", "b", "a", "c", "a", "b"} {
+		top.Add(key)
+	}
+
+	entries, total := top.Top(10)
+	if total != 6 || len(entries) != 3 {
+		t.Fatalf("Expected 3 keys of 6 additions but got %d of %f", len(entries), total)
+	}
+	for i, expected := range []TopKEntry{{"a", 3, 0}, {"b", 2, 0}, {"c", 1, 0}} {
+		if entries[i] != expected {
+			t.Errorf("Expected %v at %d but got %v", expected, i, entries[i])
+		}
+	}
+}
+
+func BenchmarkTopK(b *testing.B) {
+	top := NewTopK(400)
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("name%d.example.com", i)
+	}
+	random := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		top.Add(keys[random.Intn(len(keys))])
+	}
+}